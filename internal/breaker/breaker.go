@@ -0,0 +1,131 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+// ErrOpen 熔断器处于打开状态，请求没有发出去
+var ErrOpen = errors.New("熔断器已打开")
+
+type state int
+
+const (
+	stateClosed state = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// Breaker 一个简单的熔断器。
+// 连续失败 threshold 次之后打开，打开期间所有的请求直接失败；
+// 过了 cooldown 之后进入半开状态，只放一个请求过去探测，
+// 探测成功就关闭，失败就重新打开
+type Breaker struct {
+	mu        sync.Mutex
+	state     state
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+	nowFunc   func() time.Time
+}
+
+// NewBreaker 默认连续失败 5 次打开，5 秒之后尝试恢复
+func NewBreaker(opts ...option.Option[Breaker]) *Breaker {
+	b := &Breaker{
+		threshold: 5,
+		cooldown:  5 * time.Second,
+		nowFunc:   time.Now,
+	}
+	option.Apply[Breaker](b, opts...)
+	return b
+}
+
+// WithThreshold 连续失败多少次之后打开
+func WithThreshold(threshold int) option.Option[Breaker] {
+	return func(b *Breaker) {
+		b.threshold = threshold
+	}
+}
+
+// WithCooldown 打开多久之后尝试恢复
+func WithCooldown(cooldown time.Duration) option.Option[Breaker] {
+	return func(b *Breaker) {
+		b.cooldown = cooldown
+	}
+}
+
+// Allow 是否可以发请求。返回 true 之后必须调用 Success 或者 Failure
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case stateOpen:
+		if b.nowFunc().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = stateHalfOpen
+		b.probing = true
+		return true
+	case stateHalfOpen:
+		// 已经有一个请求在探测了
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = stateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		b.state = stateOpen
+		b.openedAt = b.nowFunc()
+		b.probing = false
+	}
+}
+
+// Do 在熔断器的保护下执行 fn
+func (b *Breaker) Do(fn func() error) error {
+	if !b.Allow() {
+		return ErrOpen
+	}
+	err := fn()
+	if err != nil {
+		b.Failure()
+	} else {
+		b.Success()
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.UnixMilli(1695571200000)
+	b := NewBreaker(WithThreshold(2), WithCooldown(time.Second))
+	b.nowFunc = func() time.Time {
+		return now
+	}
+	errMock := errors.New("模拟系统错误")
+	fail := func() error {
+		return errMock
+	}
+	succ := func() error {
+		return nil
+	}
+
+	assert.Equal(t, errMock, b.Do(fail))
+	// 成功之后重新计数
+	assert.NoError(t, b.Do(succ))
+	assert.Equal(t, errMock, b.Do(fail))
+	assert.Equal(t, errMock, b.Do(fail))
+	// 连续失败两次，打开
+	assert.Equal(t, ErrOpen, b.Do(succ))
+
+	// 半开状态只放一个请求过去
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	// 探测失败，重新打开
+	b.Failure()
+	assert.False(t, b.Allow())
+
+	now = now.Add(time.Second)
+	assert.NoError(t, b.Do(succ))
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+}
@@ -14,12 +14,14 @@
 
 package crawlerdetect
 
+import "github.com/ecodeclub/ekit/bean/option"
+
 type BaiduStrategy struct {
 	*UniversalStrategy
 }
 
-func NewBaiduStrategy() *BaiduStrategy {
+func NewBaiduStrategy(opts ...option.Option[UniversalStrategy]) *BaiduStrategy {
 	return &BaiduStrategy{
-		UniversalStrategy: NewUniversalStrategy([]string{"baidu.com", "baidu.jp"}),
+		UniversalStrategy: NewUniversalStrategy([]string{"baidu.com", "baidu.jp"}, opts...),
 	}
 }
@@ -14,12 +14,14 @@
 
 package crawlerdetect
 
+import "github.com/ecodeclub/ekit/bean/option"
+
 type BingStrategy struct {
 	*UniversalStrategy
 }
 
-func NewBingStrategy() *BingStrategy {
+func NewBingStrategy(opts ...option.Option[UniversalStrategy]) *BingStrategy {
 	return &BingStrategy{
-		UniversalStrategy: NewUniversalStrategy([]string{"search.msn.com"}),
+		UniversalStrategy: NewUniversalStrategy([]string{"search.msn.com"}, opts...),
 	}
 }
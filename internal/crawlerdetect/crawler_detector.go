@@ -15,9 +15,14 @@
 package crawlerdetect
 
 import (
+	"context"
+	"errors"
 	"net"
 	"slices"
 	"strings"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
 )
 
 const (
@@ -27,29 +32,114 @@ const (
 	Sogou  = "sogou"
 )
 
-var strategyMap = map[string]Strategy{
-	Baidu:  NewBaiduStrategy(),
-	Bing:   NewBingStrategy(),
-	Google: NewGoogleStrategy(),
-	Sogou:  NewSoGouStrategy(),
-}
+var (
+	// 默认的策略共享同一个 Resolver 和 Cache
+	defaultResolver Resolver = NewNetResolver(3 * time.Second)
+	defaultCache             = NewCache(10000, time.Hour, 10*time.Minute)
+
+	strategyMap = map[string]Strategy{
+		Baidu:  NewBaiduStrategy(),
+		Bing:   NewBingStrategy(),
+		Google: NewGoogleStrategy(),
+		Sogou:  NewSoGouStrategy(),
+	}
+
+	constructors = map[string]func(opts ...option.Option[UniversalStrategy]) Strategy{
+		Baidu: func(opts ...option.Option[UniversalStrategy]) Strategy {
+			return NewBaiduStrategy(opts...)
+		},
+		Bing: func(opts ...option.Option[UniversalStrategy]) Strategy {
+			return NewBingStrategy(opts...)
+		},
+		Google: func(opts ...option.Option[UniversalStrategy]) Strategy {
+			return NewGoogleStrategy(opts...)
+		},
+		Sogou: func(opts ...option.Option[UniversalStrategy]) Strategy {
+			return NewSoGouStrategy(opts...)
+		},
+	}
+)
 
 type Strategy interface {
 	CheckCrawler(ip string) (bool, error)
+	// CheckCrawlerContext ctx 用于控制 DNS 查询的超时
+	CheckCrawlerContext(ctx context.Context, ip string) (bool, error)
 }
 
+// UniversalStrategy 先反向查询 ip 的域名，域名匹配之后再正向查询，确认域名确实解析到这个 ip
 type UniversalStrategy struct {
 	Hosts []string
+
+	resolver Resolver
+	cache    *Cache
+	// 有一些搜索引擎的域名没有正向解析，例如搜狗，只能校验反向查询的结果
+	skipForward bool
+	// 不同的策略共享缓存的时候区分开
+	cacheKey string
+}
+
+func NewUniversalStrategy(hosts []string, opts ...option.Option[UniversalStrategy]) *UniversalStrategy {
+	res := &UniversalStrategy{
+		Hosts:    hosts,
+		resolver: defaultResolver,
+		cache:    defaultCache,
+	}
+	option.Apply[UniversalStrategy](res, opts...)
+	res.cacheKey = strings.Join(hosts, ",") + "|"
+	if res.skipForward {
+		res.cacheKey = "ptr:" + res.cacheKey
+	}
+	return res
+}
+
+// WithResolver 设置 DNS 查询的实现，默认是带 3 秒超时的 net.DefaultResolver
+func WithResolver(r Resolver) option.Option[UniversalStrategy] {
+	return func(s *UniversalStrategy) {
+		s.resolver = r
+	}
 }
 
-func NewUniversalStrategy(hosts []string) *UniversalStrategy {
-	return &UniversalStrategy{
-		Hosts: hosts,
+// WithCache 设置校验结果的缓存，nil 表示不缓存。
+// 默认所有的策略共享一个 10000 条的缓存，是爬虫的结果缓存 1 小时，不是爬虫的缓存 10 分钟
+func WithCache(c *Cache) option.Option[UniversalStrategy] {
+	return func(s *UniversalStrategy) {
+		s.cache = c
+	}
+}
+
+func withoutForward() option.Option[UniversalStrategy] {
+	return func(s *UniversalStrategy) {
+		s.skipForward = true
 	}
 }
 
 func (s *UniversalStrategy) CheckCrawler(ip string) (bool, error) {
-	names, err := net.LookupAddr(ip)
+	return s.CheckCrawlerContext(context.Background(), ip)
+}
+
+func (s *UniversalStrategy) CheckCrawlerContext(ctx context.Context, ip string) (bool, error) {
+	if s.cache != nil {
+		if matched, ok := s.cache.Get(s.cacheKey + ip); ok {
+			return matched, nil
+		}
+	}
+	matched, err := s.check(ctx, ip)
+	if err != nil {
+		// 没有对应的 DNS 记录说明不是爬虫，其余的错误可能是暂时的，不缓存
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return false, err
+		}
+		matched = false
+	}
+	if s.cache != nil {
+		s.cache.Set(s.cacheKey+ip, matched)
+	}
+	return matched, nil
+}
+
+func (s *UniversalStrategy) check(ctx context.Context, ip string) (bool, error) {
+	names, err := s.resolver.LookupAddr(ctx, ip)
 	if err != nil {
 		return false, err
 	}
@@ -58,11 +148,11 @@ func (s *UniversalStrategy) CheckCrawler(ip string) (bool, error) {
 	}
 
 	name, matched := s.matchHost(names)
-	if !matched {
-		return false, nil
+	if !matched || s.skipForward {
+		return matched, nil
 	}
 
-	ips, err := net.LookupIP(name)
+	ips, err := s.resolver.LookupIP(ctx, "ip", name)
 	if err != nil {
 		return false, err
 	}
@@ -88,6 +178,14 @@ func (s *UniversalStrategy) matchHost(names []string) (string, bool) {
 	})
 }
 
-func NewCrawlerDetector(crawler string) Strategy {
-	return strategyMap[crawler]
+// NewCrawlerDetector 没有 opts 的时候返回默认的策略，否则创建一个新的策略
+func NewCrawlerDetector(crawler string, opts ...option.Option[UniversalStrategy]) Strategy {
+	if len(opts) == 0 {
+		return strategyMap[crawler]
+	}
+	fn, ok := constructors[crawler]
+	if !ok {
+		return nil
+	}
+	return fn(opts...)
 }
@@ -0,0 +1,90 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawlerdetect
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ecodeclub/ginx/internal/crawlerdetect/crawlertest"
+)
+
+func TestUniversalStrategy_Cache(t *testing.T) {
+	r := crawlertest.NewResolver()
+	c := NewCache(100, time.Minute, time.Minute)
+	baidu := NewBaiduStrategy(WithResolver(r), WithCache(c))
+	google := NewGoogleStrategy(WithResolver(r), WithCache(c))
+
+	// 是爬虫的结果，反向和正向各查询一次
+	matched, err := baidu.CheckCrawler("111.206.198.69")
+	require.NoError(t, err)
+	assert.True(t, matched)
+	matched, err = baidu.CheckCrawler("111.206.198.69")
+	require.NoError(t, err)
+	assert.True(t, matched)
+	assert.Equal(t, 2, r.Lookups())
+
+	// 共享缓存的时候，不同的策略互不影响
+	matched, err = google.CheckCrawler("111.206.198.69")
+	require.NoError(t, err)
+	assert.False(t, matched)
+	assert.Equal(t, 3, r.Lookups())
+
+	// 没有反向解析也会缓存
+	for i := 0; i < 2; i++ {
+		matched, err = baidu.CheckCrawler("166.249.90.77")
+		require.NoError(t, err)
+		assert.False(t, matched)
+	}
+	assert.Equal(t, 4, r.Lookups())
+
+	// 出错的结果不缓存
+	r.Err = errors.New("timeout")
+	for i := 0; i < 2; i++ {
+		_, err = baidu.CheckCrawler("157.55.39.1")
+		assert.Equal(t, r.Err, err)
+	}
+	assert.Equal(t, 6, r.Lookups())
+}
+
+func TestUniversalStrategy_Context(t *testing.T) {
+	r := crawlertest.NewResolver()
+	s := NewBaiduStrategy(WithResolver(r), WithCache(nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.CheckCrawlerContext(ctx, "111.206.198.69")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, r.Lookups())
+}
+
+func TestNewCrawlerDetector(t *testing.T) {
+	assert.Same(t, strategyMap[Baidu], NewCrawlerDetector(Baidu))
+	assert.Nil(t, NewCrawlerDetector("unknown", WithCache(nil)))
+
+	r := crawlertest.NewResolver()
+	for _, crawler := range []string{Baidu, Bing, Google, Sogou} {
+		s := NewCrawlerDetector(crawler, WithResolver(r), WithCache(nil))
+		require.NotNil(t, s)
+		assert.NotSame(t, strategyMap[crawler], s)
+	}
+	matched, err := NewCrawlerDetector(Sogou, WithResolver(r)).CheckCrawler("123.126.113.110")
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crawlertest 提供测试爬虫校验用的 DNS 查询，不会访问真实的 DNS 服务器
+package crawlertest
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Resolver 使用固定的 DNS 记录，并且记录查询的次数。
+// context 被取消或者超时的时候返回 context 的错误
+type Resolver struct {
+	// PTR 反向解析的记录，ip 到域名
+	PTR map[string][]string
+	// IPs 正向解析的记录，域名到 ip
+	IPs map[string][]string
+	// Err 不为 nil 的时候所有的查询都返回这个错误
+	Err error
+
+	mu      sync.Mutex
+	lookups int
+}
+
+// NewResolver 包含各个爬虫的真实记录，以及几条伪造的记录
+func NewResolver() *Resolver {
+	return &Resolver{
+		PTR: map[string][]string{
+			"111.206.198.69":  {"baiduspider-111-206-198-69.crawl.baidu.com."},
+			"157.55.39.1":     {"msnbot-157-55-39-1.search.msn.com."},
+			"66.249.66.1":     {"crawl-66-249-66-1.googlebot.com."},
+			"66.249.90.77":    {"rate-limited-proxy-66-249-90-77.google.com."},
+			"35.247.243.240":  {"240.243.247.35.bc.googleusercontent.com."},
+			"123.126.113.110": {"sogouspider-123-126-113-110.crawl.sogou.com."},
+			// 伪造的反向解析，正向解析不到这个 ip
+			"10.0.0.1": {"fake.crawl.baidu.com."},
+			"10.0.0.2": {"host.example.com."},
+		},
+		IPs: map[string][]string{
+			"baiduspider-111-206-198-69.crawl.baidu.com.": {"111.206.198.69"},
+			"msnbot-157-55-39-1.search.msn.com.":          {"157.55.39.1"},
+			"crawl-66-249-66-1.googlebot.com.":            {"66.249.66.1"},
+			"rate-limited-proxy-66-249-90-77.google.com.": {"66.249.90.77"},
+			"240.243.247.35.bc.googleusercontent.com.":    {"35.247.243.240"},
+			"fake.crawl.baidu.com.":                       {"111.206.198.70"},
+		},
+	}
+}
+
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if err := r.lookup(ctx); err != nil {
+		return nil, err
+	}
+	if net.ParseIP(addr) == nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	names, ok := r.PTR[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (r *Resolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if err := r.lookup(ctx); err != nil {
+		return nil, err
+	}
+	vals, ok := r.IPs[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	res := make([]net.IP, 0, len(vals))
+	for _, val := range vals {
+		res = append(res, net.ParseIP(val))
+	}
+	return res, nil
+}
+
+// Lookups 一共查询了多少次，包括出错的查询
+func (r *Resolver) Lookups() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+func (r *Resolver) lookup(ctx context.Context) error {
+	r.mu.Lock()
+	r.lookups++
+	r.mu.Unlock()
+	if r.Err != nil {
+		return r.Err
+	}
+	return ctx.Err()
+}
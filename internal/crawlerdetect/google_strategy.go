@@ -14,12 +14,14 @@
 
 package crawlerdetect
 
+import "github.com/ecodeclub/ekit/bean/option"
+
 type GoogleStrategy struct {
 	*UniversalStrategy
 }
 
-func NewGoogleStrategy() *GoogleStrategy {
+func NewGoogleStrategy(opts ...option.Option[UniversalStrategy]) *GoogleStrategy {
 	return &GoogleStrategy{
-		UniversalStrategy: NewUniversalStrategy([]string{"googlebot.com", "google.com", "googleusercontent.com"}),
+		UniversalStrategy: NewUniversalStrategy([]string{"googlebot.com", "google.com", "googleusercontent.com"}, opts...),
 	}
 }
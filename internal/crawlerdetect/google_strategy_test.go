@@ -16,38 +16,39 @@ package crawlerdetect
 
 import (
 	"errors"
-	"log"
 	"net"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/ecodeclub/ginx/internal/crawlerdetect/crawlertest"
 )
 
 func TestGoogleStrategy(t *testing.T) {
-	s := NewGoogleStrategy()
+	s := NewGoogleStrategy(WithResolver(crawlertest.NewResolver()), WithCache(nil))
 	require.NotNil(t, s)
 	testCases := []struct {
 		name    string
 		ip      string
 		matched bool
-		errFunc require.ErrorAssertionFunc
+		wantErr bool
 	}{
 		{
 			name:    "无效 ip",
 			ip:      "256.0.0.0",
 			matched: false,
-			errFunc: func(t require.TestingT, err error, i ...interface{}) {
-				var dnsError *net.DNSError
-				if !errors.As(err, &dnsError) {
-					log.Fatal(err)
-				}
-			},
+			wantErr: true,
 		},
 		{
-			name:    "非谷歌 ip",
+			name:    "没有反向解析",
 			ip:      "166.249.90.77",
 			matched: false,
 		},
+		{
+			name:    "非谷歌 ip",
+			ip:      "10.0.0.2",
+			matched: false,
+		},
 		{
 			name:    "谷歌 ip",
 			ip:      "66.249.90.77",
@@ -58,8 +59,11 @@ func TestGoogleStrategy(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			m, err := s.CheckCrawler(tc.ip)
-			if err != nil {
-				tc.errFunc(t, err)
+			if tc.wantErr {
+				var dnsError *net.DNSError
+				require.True(t, errors.As(err, &dnsError))
+			} else {
+				require.NoError(t, err)
 			}
 			require.Equal(t, tc.matched, m)
 		})
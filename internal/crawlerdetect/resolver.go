@@ -0,0 +1,148 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawlerdetect
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver 校验爬虫需要的 DNS 查询，默认使用 net.Resolver
+type Resolver interface {
+	// LookupAddr 反向查询 ip 对应的域名
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	// LookupIP 正向查询域名对应的 ip，network 是 ip、ip4 或者 ip6
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// NetResolver 在 net.Resolver 的基础上，给每一次查询加上超时时间
+type NetResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+// NewNetResolver 使用 net.DefaultResolver，timeout 小于等于 0 表示不设置超时时间
+func NewNetResolver(timeout time.Duration) *NetResolver {
+	return &NetResolver{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+	}
+}
+
+func (r *NetResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.resolver.LookupAddr(ctx, addr)
+}
+
+func (r *NetResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.resolver.LookupIP(ctx, network, host)
+}
+
+func (r *NetResolver) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// Cache 缓存校验的结果，包括不是爬虫的结果，容量满了之后淘汰最久没有使用的。
+// 查询出错的结果不会缓存
+type Cache struct {
+	capacity int
+	// 是爬虫的结果缓存 ttl，不是爬虫的缓存 negativeTTL
+	ttl         time.Duration
+	negativeTTL time.Duration
+	nowFunc     func() time.Time
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	// 最近使用的在前面
+	lru *list.List
+}
+
+type cacheItem struct {
+	key      string
+	matched  bool
+	expireAt time.Time
+}
+
+// NewCache 一般来说不是爬虫的结果应该缓存更短的时间，
+// 避免搜索引擎新增的 ip 被拒绝太久
+func NewCache(capacity int, ttl, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		nowFunc:     time.Now,
+		items:       make(map[string]*list.Element, capacity),
+		lru:         list.New(),
+	}
+}
+
+func (c *Cache) Get(key string) (matched bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return false, false
+	}
+	item := elem.Value.(*cacheItem)
+	if c.nowFunc().After(item.expireAt) {
+		c.remove(elem)
+		return false, false
+	}
+	c.lru.MoveToFront(elem)
+	return item.matched, true
+}
+
+func (c *Cache) Set(key string, matched bool) {
+	ttl := c.negativeTTL
+	if matched {
+		ttl = c.ttl
+	}
+	if c.capacity <= 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expireAt := c.nowFunc().Add(ttl)
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*cacheItem)
+		item.matched, item.expireAt = matched, expireAt
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cacheItem{key: key, matched: matched, expireAt: expireAt})
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.items, elem.Value.(*cacheItem).key)
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawlerdetect
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := NewCache(2, time.Minute, time.Second)
+	c.nowFunc = func() time.Time { return now }
+
+	c.Set("a", true)
+	c.Set("b", false)
+	matched, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.True(t, matched)
+	matched, ok = c.Get("b")
+	assert.True(t, ok)
+	assert.False(t, matched)
+
+	// 淘汰最久没有使用的 a
+	c.Set("c", true)
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+
+	// 不是爬虫的结果先过期
+	now = now.Add(2 * time.Second)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+}
+
+func TestNetResolver_Timeout(t *testing.T) {
+	r := NewNetResolver(10 * time.Millisecond)
+	// DNS 服务器一直不响应
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	start := time.Now()
+	_, err := r.LookupIP(context.Background(), "ip", "example.com")
+	assert.Error(t, err)
+	_, err = r.LookupAddr(context.Background(), "111.206.198.69")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
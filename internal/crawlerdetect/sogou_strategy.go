@@ -14,34 +14,15 @@
 
 package crawlerdetect
 
-import (
-	"net"
-	"slices"
-	"strings"
-)
+import "github.com/ecodeclub/ekit/bean/option"
 
+// SoGouStrategy 搜狗爬虫的域名没有正向解析，所以只校验反向查询的结果
 type SoGouStrategy struct {
-	Hosts []string
+	*UniversalStrategy
 }
 
-func NewSoGouStrategy() *SoGouStrategy {
+func NewSoGouStrategy(opts ...option.Option[UniversalStrategy]) *SoGouStrategy {
 	return &SoGouStrategy{
-		Hosts: []string{"sogou.com"},
+		UniversalStrategy: NewUniversalStrategy([]string{"sogou.com"}, append([]option.Option[UniversalStrategy]{withoutForward()}, opts...)...),
 	}
 }
-
-func (s *SoGouStrategy) CheckCrawler(ip string) (bool, error) {
-	names, err := net.LookupAddr(ip)
-	if err != nil || len(names) == 0 {
-		return false, err
-	}
-	return s.matchHost(names), nil
-}
-
-func (s *SoGouStrategy) matchHost(names []string) bool {
-	return slices.ContainsFunc(s.Hosts, func(host string) bool {
-		return slices.ContainsFunc(names, func(name string) bool {
-			return strings.Contains(name, host)
-		})
-	})
-}
@@ -18,7 +18,6 @@ package e2e
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -26,6 +25,7 @@ import (
 
 	"github.com/ecodeclub/ginx/middlewares/activelimit/redislimit"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -77,7 +77,7 @@ func TestBuilder_e2e_ActiveRedisLimit(t *testing.T) {
 			interval: time.Millisecond * 10,
 			after: func(key string) (int64, error) {
 
-				return redisClient.Get(context.Background(), key).Int64()
+				return redisClient.ZCard(context.Background(), "{"+key+"}").Result()
 			},
 
 			maxCount: 1,
@@ -110,7 +110,7 @@ func TestBuilder_e2e_ActiveRedisLimit(t *testing.T) {
 			interval: time.Millisecond * 50,
 			after: func(key string) (int64, error) {
 
-				return redisClient.Get(context.Background(), key).Int64()
+				return redisClient.ZCard(context.Background(), "{"+key+"}").Result()
 			},
 			maxCount:   1,
 			key:        "test",
@@ -139,7 +139,7 @@ func TestBuilder_e2e_ActiveRedisLimit(t *testing.T) {
 			interval: time.Millisecond * 200,
 			after: func(key string) (int64, error) {
 
-				return redisClient.Get(context.Background(), key).Int64()
+				return redisClient.ZCard(context.Background(), "{"+key+"}").Result()
 			},
 			maxCount:   1,
 			key:        "test",
@@ -152,8 +152,7 @@ func TestBuilder_e2e_ActiveRedisLimit(t *testing.T) {
 	for _, tc := range testCases {
 		//这里延时的原因是 保证builder 中的defer 延时操作不会导致测试的异常
 		time.Sleep(time.Millisecond * 100)
-		redisClient.Del(context.Background(), tc.key)
-		fmt.Println(redisClient.Get(context.Background(), tc.key).Int64())
+		redisClient.Del(context.Background(), "{"+tc.key+"}")
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 
@@ -181,3 +180,126 @@ func TestBuilder_e2e_ActiveRedisLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisActiveLimit_e2e_Lease(t *testing.T) {
+	redisClient := newRedisTestClient()
+	defer func() {
+		_ = redisClient.Close()
+	}()
+	ctx := context.Background()
+	key := "TestRedisActiveLimit_e2e_Lease"
+	require.NoError(t, redisClient.Del(ctx, "{"+key+"}", "{"+key+"}:instance:crashed").Err())
+
+	// 模拟一个实例拿到了租约之后崩溃了，没有释放
+	crashed := redislimit.NewRedisActiveLimit(redisClient, 1, key).
+		SetInstanceID("crashed").
+		SetLeaseTTL(200 * time.Millisecond)
+	block := make(chan struct{})
+	crashedServer := gin.Default()
+	crashedServer.Use(crashed.Build())
+	crashedServer.GET("/activelimit", func(ctx *gin.Context) {
+		<-block
+	})
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, "/activelimit", nil)
+		require.NoError(t, err)
+		crashedServer.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	total, instance, err := crashed.Active(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, int64(1), instance)
+
+	limit := redislimit.NewRedisActiveLimit(redisClient, 1, key).SetInstanceID("alive")
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/activelimit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	serve := func() int {
+		req, err := http.NewRequest(http.MethodGet, "/activelimit", nil)
+		require.NoError(t, err)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	// 长时间的请求会续约，不会被回收
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	// 把还在处理的请求的租约换成一个快要过期的，模拟崩溃的实例留下的租约
+	require.NoError(t, redisClient.ZAdd(ctx, "{"+key+"}", redis.Z{
+		Score:  float64(time.Now().Add(100 * time.Millisecond).UnixMilli()),
+		Member: "crashed:orphan",
+	}).Err())
+	require.NoError(t, redisClient.ZRem(ctx, "{"+key+"}", "crashed:1").Err())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve())
+
+	total, instance, err = limit.Active(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+	assert.Equal(t, int64(0), instance)
+	close(block)
+}
+
+func TestRedisActiveLimit_e2e_PerKey(t *testing.T) {
+	redisClient := newRedisTestClient()
+	defer func() {
+		_ = redisClient.Close()
+	}()
+	ctx := context.Background()
+	key := "TestRedisActiveLimit_e2e_PerKey"
+	require.NoError(t, redisClient.Del(ctx, "{"+key+"}", "{"+key+"}:key:123").Err())
+
+	limit := redislimit.NewRedisActiveLimit(redisClient, 2, key).
+		SetKeyGenFunc(func(ctx *gin.Context) string {
+			return ctx.GetHeader("X-Uid")
+		}).
+		SetMaxActivePerKey(1)
+	unblock := make(chan struct{})
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/activelimit", func(ctx *gin.Context) {
+		if ctx.Query("block") == "true" {
+			<-unblock
+		}
+		ctx.Status(http.StatusOK)
+	})
+	serve := func(uid string, block string) int {
+		req, err := http.NewRequest(http.MethodGet, "/activelimit?block="+block, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Uid", uid)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Equal(t, http.StatusOK, serve("123", "true"))
+	}()
+	assert.Eventually(t, func() bool {
+		total, _, err := limit.Active(ctx)
+		return err == nil && total == 1
+	}, time.Second, time.Millisecond*10)
+
+	assert.Equal(t, http.StatusTooManyRequests, serve("123", "false"))
+	assert.Equal(t, http.StatusOK, serve("456", "false"))
+
+	// 小于等于 0 不限制单个 key
+	limit.SetMaxActivePerKey(0)
+	assert.Equal(t, http.StatusOK, serve("123", "false"))
+
+	close(unblock)
+	<-done
+	// 没有活跃请求的 key 被删掉了
+	exists, err := redisClient.Exists(ctx, "{"+key+"}:key:123", "{"+key+"}:key:456").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+}
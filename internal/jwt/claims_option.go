@@ -28,6 +28,9 @@ type Options struct {
 	Method        jwt.SigningMethod // 签名方式
 	Issuer        string            // 签发人
 	genIDFn       func() string     // 生成 JWT ID (jti) 的函数
+	// JWEKey 不为空的时候, 签名之后的 token 会再用 JWE 加密,
+	// 也就是 JWS-in-JWE, 这样 claims 里面的数据就不能被直接读取
+	JWEKey []byte
 }
 
 // NewOptions 定义一个 JWT 配置.
@@ -76,3 +79,11 @@ func WithGenIDFunc(fn func() string) option.Option[Options] {
 		o.genIDFn = fn
 	}
 }
+
+// WithJWEKey 开启 JWE 模式, 使用 dir + AES-GCM 加密签名后的 token.
+// key 的长度必须是 16, 24 或 32 字节, 分别对应 A128GCM, A192GCM 和 A256GCM.
+func WithJWEKey(key []byte) option.Option[Options] {
+	return func(o *Options) {
+		o.JWEKey = key
+	}
+}
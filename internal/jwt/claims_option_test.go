@@ -196,3 +196,38 @@ func TestWithGenIDFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestWithJWEKey(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() option.Option[Options]
+		want []byte
+	}{
+		{
+			name: "normal",
+			fn: func() option.Option[Options] {
+				return nil
+			},
+		},
+		{
+			name: "set_jwe_key",
+			fn: func() option.Option[Options] {
+				return WithJWEKey([]byte("0123456789abcdef"))
+			},
+			want: []byte("0123456789abcdef"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			if tt.fn() == nil {
+				got = NewOptions(defaultExpire, encryptionKey).
+					JWEKey
+			} else {
+				got = NewOptions(defaultExpire, encryptionKey,
+					tt.fn()).JWEKey
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jwt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrJWEMalformed     = errors.New("JWE 格式错误")
+	ErrJWEUnsupported   = errors.New("不支持的 JWE 算法")
+	ErrJWEDecryptFailed = errors.New("JWE 解密失败")
+)
+
+// jweHeader 是 JWE 的保护头部.
+// 只支持 alg = dir, 也就是直接使用共享密钥作为内容加密密钥.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	// Cty 为 JWT 时, 表示密文是一个嵌套的 JWS
+	Cty string `json:"cty,omitempty"`
+}
+
+// jweEnc 根据密钥长度确定内容加密算法.
+func jweEnc(key []byte) (string, error) {
+	switch len(key) {
+	case 16:
+		return "A128GCM", nil
+	case 24:
+		return "A192GCM", nil
+	case 32:
+		return "A256GCM", nil
+	default:
+		return "", fmt.Errorf("%w: 密钥长度必须是 16, 24 或 32 字节, 实际是 %d",
+			ErrJWEUnsupported, len(key))
+	}
+}
+
+// encryptJWE 将已经签名的 JWS 加密成 compact 形式的 JWE.
+// 格式为 header..iv.ciphertext.tag, 因为使用 dir 算法, 所以加密密钥部分为空.
+func encryptJWE(key []byte, jws string) (string, error) {
+	enc, err := jweEnc(key)
+	if err != nil {
+		return "", err
+	}
+	hdr, err := json.Marshal(jweHeader{Alg: "dir", Enc: enc, Cty: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	encodedHdr := base64.RawURLEncoding.EncodeToString(hdr)
+	// AAD 是编码后的头部, 这样头部被篡改也能检测出来
+	sealed := gcm.Seal(nil, iv, []byte(jws), []byte(encodedHdr))
+	tagStart := len(sealed) - gcm.Overhead()
+	return strings.Join([]string{
+		encodedHdr,
+		"",
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(sealed[:tagStart]),
+		base64.RawURLEncoding.EncodeToString(sealed[tagStart:]),
+	}, "."), nil
+}
+
+// decryptJWE 解密 compact 形式的 JWE, 返回其中嵌套的 JWS.
+func decryptJWE(key []byte, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("%w: 段数应该是 5, 实际是 %d", ErrJWEMalformed, len(parts))
+	}
+	hdrBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrJWEMalformed, err)
+	}
+	var hdr jweHeader
+	if err = json.Unmarshal(hdrBytes, &hdr); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrJWEMalformed, err)
+	}
+	enc, err := jweEnc(key)
+	if err != nil {
+		return "", err
+	}
+	if hdr.Alg != "dir" || hdr.Enc != enc || parts[1] != "" {
+		return "", fmt.Errorf("%w: alg=%s, enc=%s", ErrJWEUnsupported, hdr.Alg, hdr.Enc)
+	}
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrJWEMalformed, err)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrJWEMalformed, err)
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrJWEMalformed, err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return "", fmt.Errorf("%w: iv 或者 tag 长度不对", ErrJWEMalformed)
+	}
+	plain, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", ErrJWEDecryptFailed
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
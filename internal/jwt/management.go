@@ -72,11 +72,23 @@ func (m *Management[T]) GenerateAccessToken(data T) (string, error) {
 		},
 	}
 	token := jwt.NewWithClaims(m.accessJWTOptions.Method, claims)
-	return token.SignedString([]byte(m.accessJWTOptions.EncryptionKey))
+	signed, err := token.SignedString([]byte(m.accessJWTOptions.EncryptionKey))
+	if err != nil || len(m.accessJWTOptions.JWEKey) == 0 {
+		return signed, err
+	}
+	return encryptJWE(m.accessJWTOptions.JWEKey, signed)
 }
 
 // VerifyAccessToken 校验资源 token.
 func (m *Management[T]) VerifyAccessToken(token string, opts ...jwt.ParserOption) (RegisteredClaims[T], error) {
+	if len(m.accessJWTOptions.JWEKey) > 0 {
+		// 先解密, 再校验里面嵌套的 JWS
+		jws, err := decryptJWE(m.accessJWTOptions.JWEKey, token)
+		if err != nil {
+			return RegisteredClaims[T]{}, fmt.Errorf("验证失败: %v", err)
+		}
+		token = jws
+	}
 	t, err := jwt.ParseWithClaims(token, &RegisteredClaims[T]{},
 		func(*jwt.Token) (interface{}, error) {
 			return []byte(m.accessJWTOptions.DecryptKey), nil
@@ -0,0 +1,24 @@
+-- 配额对象, 已经带上了周期
+local key = KEYS[1]
+-- 套餐里面每个周期的配额
+local limit = tonumber(ARGV[1])
+-- 这次请求要消耗多少配额
+local cost = tonumber(ARGV[2])
+-- 周期结束的时间, 毫秒
+local expireAt = tonumber(ARGV[3])
+
+local vals = redis.call('HMGET', key, 'used', 'bonus')
+local used = tonumber(vals[1]) or 0
+-- 管理员额外充值的配额
+local bonus = tonumber(vals[2]) or 0
+local total = limit + bonus
+local limited = 0
+if used + cost > total then
+    -- 配额用完了
+    limited = 1
+else
+    used = redis.call('HINCRBY', key, 'used', cost)
+    redis.call('PEXPIREAT', key, expireAt)
+end
+-- 返回: 是否超过配额, 已经使用的配额, 总配额
+return { limited, used, total }
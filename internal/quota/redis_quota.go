@@ -0,0 +1,144 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed quota.lua
+var luaQuota string
+
+var _ Quota = &RedisQuota{}
+
+// RedisQuota 基于 Redis 的配额。
+// 每个 key 每个周期一个 hash，key 里面带上了周期，所以进入新的周期之后自然就是新的计数，
+// 旧的 hash 在周期结束的时候过期
+type RedisQuota struct {
+	cmd    redis.Cmdable
+	period Period
+	limit  int64
+	loc    *time.Location
+	prefix string
+
+	nowFunc func() time.Time
+}
+
+// NewRedisQuota 每个 period 允许 limit 次调用，默认按照 UTC 对齐
+func NewRedisQuota(cmd redis.Cmdable, period Period, limit int64,
+	opts ...option.Option[RedisQuota]) *RedisQuota {
+	res := &RedisQuota{
+		cmd:     cmd,
+		period:  period,
+		limit:   limit,
+		loc:     time.UTC,
+		prefix:  "quota",
+		nowFunc: time.Now,
+	}
+	option.Apply[RedisQuota](res, opts...)
+	return res
+}
+
+// WithLocation 设置按照哪个时区的日历对齐周期
+func WithLocation(loc *time.Location) option.Option[RedisQuota] {
+	return func(q *RedisQuota) {
+		q.loc = loc
+	}
+}
+
+// WithPrefix 设置 Redis key 的前缀，默认是 quota
+func WithPrefix(prefix string) option.Option[RedisQuota] {
+	return func(q *RedisQuota) {
+		q.prefix = prefix
+	}
+}
+
+func (q *RedisQuota) Consume(ctx context.Context, key string, cost int64) (Usage, error) {
+	redisKey, end := q.key(key)
+	vals, err := q.cmd.Eval(ctx, luaQuota, []string{redisKey},
+		q.limit, cost, end.UnixMilli()).Int64Slice()
+	if err != nil {
+		return Usage{}, err
+	}
+	if len(vals) != 3 {
+		return Usage{}, fmt.Errorf("配额脚本返回值错误: %v", vals)
+	}
+	return Usage{
+		Exceeded: vals[0] == 1,
+		Used:     vals[1],
+		Limit:    vals[2],
+		ResetAt:  end,
+	}, nil
+}
+
+func (q *RedisQuota) Get(ctx context.Context, key string) (Usage, error) {
+	redisKey, end := q.key(key)
+	vals, err := q.cmd.HMGet(ctx, redisKey, "used", "bonus").Result()
+	if err != nil {
+		return Usage{}, err
+	}
+	used, err := parseInt(vals[0])
+	if err != nil {
+		return Usage{}, err
+	}
+	bonus, err := parseInt(vals[1])
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		Limit:   q.limit + bonus,
+		Used:    used,
+		ResetAt: end,
+	}, nil
+}
+
+func (q *RedisQuota) Reset(ctx context.Context, key string) error {
+	redisKey, _ := q.key(key)
+	return q.cmd.Del(ctx, redisKey).Err()
+}
+
+func (q *RedisQuota) TopUp(ctx context.Context, key string, amount int64) error {
+	redisKey, end := q.key(key)
+	_, err := q.cmd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, redisKey, "bonus", amount)
+		pipe.PExpireAt(ctx, redisKey, end)
+		return nil
+	})
+	return err
+}
+
+// key 返回当前周期的 Redis key 以及周期结束的时间
+func (q *RedisQuota) key(key string) (string, time.Time) {
+	start, end := q.period.Window(q.nowFunc(), q.loc)
+	return q.prefix + ":" + key + ":" + q.period.format(start), end
+}
+
+func parseInt(val any) (int64, error) {
+	if val == nil {
+		return 0, nil
+	}
+	str, ok := val.(string)
+	if !ok {
+		return 0, fmt.Errorf("配额数据格式错误: %v", val)
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
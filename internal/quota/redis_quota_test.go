@@ -0,0 +1,74 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build e2e
+
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisQuota(t *testing.T) {
+	cmd := redis.NewClient(&redis.Options{
+		Addr: "localhost:16379",
+	})
+	// 周期结束的时候 key 会过期，所以不能用过去的时间
+	now := time.Date(2099, 9, 25, 13, 14, 0, 0, time.UTC)
+	q := NewRedisQuota(cmd, Monthly, 3)
+	q.nowFunc = func() time.Time {
+		return now
+	}
+	ctx := context.Background()
+	key := "TestRedisQuota"
+	require.NoError(t, q.Reset(ctx, key))
+	resetAt := time.Date(2099, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	u, err := q.Consume(ctx, key, 2)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Limit: 3, Used: 2, ResetAt: resetAt}, u)
+
+	// 配额不够，一个都不扣
+	u, err = q.Consume(ctx, key, 2)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Exceeded: true, Limit: 3, Used: 2, ResetAt: resetAt}, u)
+
+	// 充值之后可以继续使用
+	require.NoError(t, q.TopUp(ctx, key, 5))
+	u, err = q.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Limit: 8, Used: 2, ResetAt: resetAt}, u)
+	u, err = q.Consume(ctx, key, 2)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Limit: 8, Used: 4, ResetAt: resetAt}, u)
+
+	// 进入下一个月，重新计数，充值的配额也失效了
+	now = resetAt
+	u, err = q.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Limit: 3, ResetAt: time.Date(2099, 11, 1, 0, 0, 0, 0, time.UTC)}, u)
+
+	// 重置
+	now = resetAt.Add(-time.Hour)
+	require.NoError(t, q.Reset(ctx, key))
+	u, err = q.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Limit: 3, ResetAt: resetAt}, u)
+}
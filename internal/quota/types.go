@@ -0,0 +1,85 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"context"
+	"time"
+)
+
+// Quota 长周期的配额，例如每个 API key 每个月 10000 次调用
+//
+//go:generate mockgen -source=types.go -package=quotamocks -destination=./mocks/quota.mock.go
+type Quota interface {
+	// Consume 消耗 cost 个配额，配额不够的时候一个都不扣，并且 Usage.Exceeded 为 true
+	Consume(ctx context.Context, key string, cost int64) (Usage, error)
+	// Get 查询当前周期的使用情况，不会消耗配额
+	Get(ctx context.Context, key string) (Usage, error)
+	// Reset 清空当前周期已经使用的配额以及额外充值的配额
+	Reset(ctx context.Context, key string) error
+	// TopUp 给当前周期额外充值 amount 个配额，周期结束之后就失效了
+	TopUp(ctx context.Context, key string, amount int64) error
+}
+
+// Usage 当前周期的配额使用情况
+type Usage struct {
+	// Exceeded 这一次有没有超过配额
+	Exceeded bool
+	// Limit 当前周期的总配额，包括额外充值的部分
+	Limit int64
+	// Used 当前周期已经使用的配额
+	Used int64
+	// ResetAt 当前周期结束的时间
+	ResetAt time.Time
+}
+
+// Remaining 当前周期还剩多少配额
+func (u Usage) Remaining() int64 {
+	if u.Used >= u.Limit {
+		return 0
+	}
+	return u.Limit - u.Used
+}
+
+// Period 配额的周期，按照日历对齐
+type Period int
+
+const (
+	// Daily 每天零点重置
+	Daily Period = iota
+	// Monthly 每个月一号零点重置
+	Monthly
+)
+
+// Window 返回 now 所在周期的开始和结束时间，按照 loc 时区的日历对齐
+func (p Period) Window(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	now = now.In(loc)
+	switch p {
+	case Monthly:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// format 周期在 Redis key 里面的表示
+func (p Period) format(start time.Time) string {
+	if p == Monthly {
+		return start.Format("200601")
+	}
+	return start.Format("20060102")
+}
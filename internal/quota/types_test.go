@@ -0,0 +1,81 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriod_Window(t *testing.T) {
+	shanghai := time.FixedZone("Asia/Shanghai", 8*3600)
+	testCases := []struct {
+		name   string
+		period Period
+		now    time.Time
+		loc    *time.Location
+
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "按天",
+			period:    Daily,
+			now:       time.Date(2023, 9, 25, 13, 14, 0, 0, time.UTC),
+			loc:       time.UTC,
+			wantStart: time.Date(2023, 9, 25, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2023, 9, 26, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// UTC 的 9 月 25 日 20 点已经是北京时间的 26 日了
+			name:      "按天，时区",
+			period:    Daily,
+			now:       time.Date(2023, 9, 25, 20, 0, 0, 0, time.UTC),
+			loc:       shanghai,
+			wantStart: time.Date(2023, 9, 26, 0, 0, 0, 0, shanghai),
+			wantEnd:   time.Date(2023, 9, 27, 0, 0, 0, 0, shanghai),
+		},
+		{
+			name:      "按月",
+			period:    Monthly,
+			now:       time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC),
+			loc:       time.UTC,
+			wantStart: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "按月，时区",
+			period:    Monthly,
+			now:       time.Date(2024, 1, 31, 16, 0, 0, 0, time.UTC),
+			loc:       shanghai,
+			wantStart: time.Date(2024, 2, 1, 0, 0, 0, 0, shanghai),
+			wantEnd:   time.Date(2024, 3, 1, 0, 0, 0, 0, shanghai),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end := tc.period.Window(tc.now, tc.loc)
+			assert.True(t, tc.wantStart.Equal(start), start)
+			assert.True(t, tc.wantEnd.Equal(end), end)
+		})
+	}
+}
+
+func TestUsage_Remaining(t *testing.T) {
+	assert.Equal(t, int64(3), Usage{Limit: 10, Used: 7}.Remaining())
+	assert.Equal(t, int64(0), Usage{Limit: 10, Used: 12}.Remaining())
+}
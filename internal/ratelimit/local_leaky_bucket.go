@@ -0,0 +1,129 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+var _ CostLimiter = &LocalLeakyBucketLimiter{}
+
+// LocalLeakyBucketLimiter 本地的漏桶限流器
+// 和令牌桶不同，漏桶会让请求排队，以固定的速率放行，也就是说突发流量会被削平。
+// 桶里最多排 capacity 个请求，桶满了之后的请求会被限流
+type LocalLeakyBucketLimiter struct {
+	// 两个请求之间的间隔
+	emission time.Duration
+	// 最多排队的时间，超过这个时间说明桶已经满了
+	maxDelay time.Duration
+	store    *localStore[leakyBucket]
+}
+
+type leakyBucket struct {
+	// 下一个请求可以被放行的时间
+	next time.Time
+}
+
+// NewLocalLeakyBucketLimiter 每 interval 放行 rate 个请求，最多排队 capacity 个请求。
+// interval 和 rate 必须大于 0，capacity 不能小于 0，否则 panic
+func NewLocalLeakyBucketLimiter(capacity int, interval time.Duration, rate int,
+	opts ...option.Option[LocalOptions]) *LocalLeakyBucketLimiter {
+	if capacity < 0 || interval <= 0 || rate <= 0 {
+		panic(fmt.Errorf("漏桶的参数不合法: capacity=%d, interval=%s, rate=%d", capacity, interval, rate))
+	}
+	o := defaultLocalOptions()
+	option.Apply[LocalOptions](&o, opts...)
+	// rate 比 interval 的纳秒数还大的时候，间隔至少是 1 纳秒
+	emission := max(interval/time.Duration(rate), time.Nanosecond)
+	maxDelay := emission * time.Duration(capacity)
+	return &LocalLeakyBucketLimiter{
+		emission: emission,
+		maxDelay: maxDelay,
+		// 最后一个请求被放行之后，桶就空了
+		store: newLocalStore[leakyBucket](maxDelay+emission, o),
+	}
+}
+
+// Limit 如果桶没有满，会阻塞到轮到这个请求为止
+// 如果在等待的过程中 ctx 过期了，会返回 ctx 的错误
+func (l *LocalLeakyBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.Decide(ctx, key)
+	return d.Limited, err
+}
+
+// Decide 和 Limit 一样，没有被限流的时候会阻塞到轮到这个请求为止
+func (l *LocalLeakyBucketLimiter) Decide(ctx context.Context, key string) (Decision, error) {
+	return l.DecideN(ctx, key, 1)
+}
+
+// DecideN 一个请求占据桶里的 cost 个位置
+func (l *LocalLeakyBucketLimiter) DecideN(ctx context.Context, key string, cost int64) (Decision, error) {
+	d, delay := l.reserve(key, cost)
+	if d.Limited || delay <= 0 {
+		return d, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return d, nil
+	case <-ctx.Done():
+		l.cancel(key, cost)
+		return d, ctx.Err()
+	}
+}
+
+// cancel 等待的时候 ctx 过期了，把预约的位置还回去，后面的请求可以使用
+func (l *LocalLeakyBucketLimiter) cancel(key string, cost int64) {
+	l.store.do(key, func(b *leakyBucket, now time.Time, fresh bool) {
+		b.next = b.next.Add(-l.emission * time.Duration(cost))
+		if b.next.Before(now) {
+			b.next = now
+		}
+	})
+}
+
+// reserve 预约一个放行的时间，返回需要等待的时间
+func (l *LocalLeakyBucketLimiter) reserve(key string, cost int64) (Decision, time.Duration) {
+	// 排在最后的那个位置需要等待的时间
+	tail := l.emission * time.Duration(cost-1)
+	var (
+		res   = Decision{Limit: int64(l.maxDelay/l.emission) + 1}
+		delay time.Duration
+	)
+	l.store.do(key, func(b *leakyBucket, now time.Time, fresh bool) {
+		at := b.next
+		if at.Before(now) {
+			at = now
+		}
+		delay = at.Sub(now)
+		if delay+tail > l.maxDelay {
+			res.Limited = true
+			// 等排在前面的请求漏掉足够的位置
+			res.RetryAfter = delay + tail - l.maxDelay
+		} else {
+			b.next = at.Add(l.emission * time.Duration(cost))
+		}
+		// 桶里还能再排多少个请求
+		queued := b.next.Sub(now)
+		res.Remaining = int64((l.maxDelay + l.emission - queued) / l.emission)
+		res.Reset = queued
+	})
+	return res, delay
+}
@@ -0,0 +1,354 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type limitStep struct {
+	name    string
+	advance time.Duration
+	key     string
+	want    bool
+}
+
+func runLimitSteps(t *testing.T, l Limiter, clock *fakeClock, steps []limitStep) {
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			clock.Add(step.advance)
+			got, err := l.Limit(context.Background(), step.key)
+			require.NoError(t, err)
+			assert.Equal(t, step.want, got)
+		})
+	}
+}
+
+func TestLocalSlidingWindowLimiter_Limit(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLocalSlidingWindowLimiter(time.Second, 2, clock.option())
+	runLimitSteps(t, l, clock, []limitStep{
+		{name: "第一个请求", key: "foo", want: false},
+		{name: "第二个请求", advance: 400 * time.Millisecond, key: "foo", want: false},
+		{name: "另外一个key", key: "bar", want: false},
+		{name: "超过阈值", advance: 400 * time.Millisecond, key: "foo", want: true},
+		// 第一个请求滑出了窗口
+		{name: "窗口滑动", advance: 300 * time.Millisecond, key: "foo", want: false},
+		{name: "又超过阈值", key: "foo", want: true},
+	})
+}
+
+func TestLocalTokenBucketLimiter_Limit(t *testing.T) {
+	clock := newFakeClock()
+	// 每秒 10 个令牌，最多突发 3 个
+	l := NewLocalTokenBucketLimiter(3, time.Second, 10, clock.option())
+	runLimitSteps(t, l, clock, []limitStep{
+		{name: "突发 1", key: "foo", want: false},
+		{name: "突发 2", key: "foo", want: false},
+		{name: "突发 3", key: "foo", want: false},
+		{name: "令牌用完", key: "foo", want: true},
+		{name: "另外一个key", key: "bar", want: false},
+		{name: "还没有放入令牌", advance: 50 * time.Millisecond, key: "foo", want: true},
+		{name: "放入了一个令牌", advance: 50 * time.Millisecond, key: "foo", want: false},
+		{name: "令牌又用完了", key: "foo", want: true},
+		// 很久没有请求，桶满了，但是不会超过容量
+		{name: "桶满了 1", advance: time.Minute, key: "foo", want: false},
+		{name: "桶满了 2", key: "foo", want: false},
+		{name: "桶满了 3", key: "foo", want: false},
+		{name: "不超过容量", key: "foo", want: true},
+	})
+}
+
+func TestTokenBucketLimiter_Invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		capacity int
+		interval time.Duration
+		rate     int
+	}{
+		{name: "rate 为 0", capacity: 3, interval: time.Second, rate: 0},
+		{name: "rate 为负数", capacity: 3, interval: time.Second, rate: -1},
+		{name: "capacity 为 0", capacity: 0, interval: time.Second, rate: 10},
+		{name: "interval 为 0", capacity: 3, interval: 0, rate: 10},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Panics(t, func() {
+				NewLocalTokenBucketLimiter(tc.capacity, tc.interval, tc.rate)
+			})
+			assert.Panics(t, func() {
+				NewRedisTokenBucketLimiter(nil, tc.capacity, tc.interval, tc.rate)
+			})
+			// 直接构造的时候，在执行脚本之前就返回错误
+			r := &RedisTokenBucketLimiter{Capacity: tc.capacity, Interval: tc.interval, Rate: tc.rate}
+			_, err := r.Decide(context.Background(), "foo")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLocalLeakyBucketLimiter_reserve(t *testing.T) {
+	clock := newFakeClock()
+	// 每秒放行 10 个，也就是每 100ms 一个，最多排队 2 个
+	l := NewLocalLeakyBucketLimiter(2, time.Second, 10, clock.option())
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		key       string
+		wantDelay time.Duration
+		want      bool
+	}{
+		{name: "直接放行", key: "foo"},
+		{name: "排队 1", key: "foo", wantDelay: 100 * time.Millisecond},
+		{name: "排队 2", key: "foo", wantDelay: 200 * time.Millisecond},
+		{name: "桶满了", key: "foo", wantDelay: 300 * time.Millisecond, want: true},
+		{name: "另外一个key", key: "bar"},
+		{name: "漏掉一个", advance: 100 * time.Millisecond, key: "foo", wantDelay: 200 * time.Millisecond},
+		{name: "桶空了", advance: time.Second, key: "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Add(tt.advance)
+			d, delay := l.reserve(tt.key, 1)
+			assert.Equal(t, tt.want, d.Limited)
+			assert.Equal(t, tt.wantDelay, delay)
+		})
+	}
+}
+
+func TestLocalLeakyBucketLimiter_Limit(t *testing.T) {
+	l := NewLocalLeakyBucketLimiter(1, time.Second, 20)
+	start := time.Now()
+	limited, err := l.Limit(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.False(t, limited)
+	// 第二个请求要排队 50ms
+	limited, err = l.Limit(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.False(t, limited)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// 等待的过程中 ctx 过期
+	l.reserve("bar", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = l.Limit(ctx, "bar")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLocalLeakyBucketLimiter_Invalid(t *testing.T) {
+	assert.Panics(t, func() { NewLocalLeakyBucketLimiter(1, time.Second, 0) })
+	assert.Panics(t, func() { NewLocalLeakyBucketLimiter(1, 0, 10) })
+	assert.Panics(t, func() { NewLocalLeakyBucketLimiter(-1, time.Second, 10) })
+
+	// rate 比 interval 的纳秒数还大，间隔按照 1 纳秒计算
+	clock := newFakeClock()
+	l := NewLocalLeakyBucketLimiter(1, time.Nanosecond, 10, clock.option())
+	d, delay := l.reserve("foo", 1)
+	assert.False(t, d.Limited)
+	assert.Equal(t, time.Duration(0), delay)
+	d, delay = l.reserve("foo", 1)
+	assert.False(t, d.Limited)
+	assert.Equal(t, time.Nanosecond, delay)
+}
+
+func TestLocalLeakyBucketLimiter_Cancel(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLocalLeakyBucketLimiter(2, time.Second, 10, clock.option())
+	_, delay := l.reserve("foo", 1)
+	assert.Equal(t, time.Duration(0), delay)
+
+	// 等待的时候 ctx 被取消了，预约的位置要还回去
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := l.Limit(ctx, "foo")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, delay = l.reserve("foo", 1)
+	assert.Equal(t, 100*time.Millisecond, delay)
+}
+
+func TestLocalLimiter_Decide(t *testing.T) {
+	clock := newFakeClock()
+	testCases := []struct {
+		name    string
+		limiter DecisionLimiter
+		// 连续调用多少次
+		times int
+		want  Decision
+	}{
+		{
+			name:    "滑动窗口没有限流",
+			limiter: NewLocalSlidingWindowLimiter(time.Second, 3, clock.option()),
+			times:   2,
+			want:    Decision{Limit: 3, Remaining: 1, Reset: time.Second},
+		},
+		{
+			name:    "滑动窗口限流",
+			limiter: NewLocalSlidingWindowLimiter(time.Second, 3, clock.option()),
+			times:   4,
+			want: Decision{Limited: true, Limit: 3, Remaining: 0,
+				Reset: time.Second, RetryAfter: time.Second},
+		},
+		{
+			name:    "令牌桶没有限流",
+			limiter: NewLocalTokenBucketLimiter(3, time.Second, 10, clock.option()),
+			times:   2,
+			want:    Decision{Limit: 3, Remaining: 1, Reset: 200 * time.Millisecond},
+		},
+		{
+			name:    "令牌桶限流",
+			limiter: NewLocalTokenBucketLimiter(3, time.Second, 10, clock.option()),
+			times:   4,
+			want: Decision{Limited: true, Limit: 3, Remaining: 0,
+				Reset: 300 * time.Millisecond, RetryAfter: 100 * time.Millisecond},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				got Decision
+				err error
+			)
+			for i := 0; i < tc.times; i++ {
+				got, err = tc.limiter.Decide(context.Background(), "foo")
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	// 漏桶每 100ms 放行一个，最多排队 2 个
+	l := NewLocalLeakyBucketLimiter(2, time.Second, 10, clock.option())
+	d, _ := l.reserve("foo", 1)
+	assert.Equal(t, Decision{Limit: 3, Remaining: 2, Reset: 100 * time.Millisecond}, d)
+	l.reserve("foo", 1)
+	l.reserve("foo", 1)
+	d, _ = l.reserve("foo", 1)
+	assert.Equal(t, Decision{Limited: true, Limit: 3, Remaining: 0,
+		Reset: 300 * time.Millisecond, RetryAfter: 100 * time.Millisecond}, d)
+}
+
+func TestLocalSlidingWindowLimiter_Reset(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLocalSlidingWindowLimiter(time.Second, 2, clock.option())
+	_, err := l.Decide(context.Background(), "foo")
+	require.NoError(t, err)
+	clock.Add(400 * time.Millisecond)
+	d, err := l.Decide(context.Background(), "foo")
+	require.NoError(t, err)
+	// 最新的请求滑出窗口之后才完全恢复
+	assert.Equal(t, Decision{Limit: 2, Remaining: 0, Reset: time.Second}, d)
+	d, err = l.Decide(context.Background(), "foo")
+	require.NoError(t, err)
+	// 最早的请求滑出窗口之后就可以重试
+	assert.Equal(t, Decision{Limited: true, Limit: 2, Remaining: 0,
+		Reset: time.Second, RetryAfter: 600 * time.Millisecond}, d)
+}
+
+func TestLocalLimiter_DecideN(t *testing.T) {
+	clock := newFakeClock()
+	sw := NewLocalSlidingWindowLimiter(time.Second, 5, clock.option())
+	tb := NewLocalTokenBucketLimiter(5, time.Second, 5, clock.option())
+	// 容量是 4，加上马上放行的一个，一共 5 个位置
+	lb := NewLocalLeakyBucketLimiter(4, time.Second, 4, clock.option())
+	testCases := []struct {
+		name   string
+		decide func(cost int64) Decision
+	}{
+		{
+			name: "滑动窗口",
+			decide: func(cost int64) Decision {
+				d, err := sw.DecideN(context.Background(), "cost", cost)
+				require.NoError(t, err)
+				return d
+			},
+		},
+		{
+			name: "令牌桶",
+			decide: func(cost int64) Decision {
+				d, err := tb.DecideN(context.Background(), "cost", cost)
+				require.NoError(t, err)
+				return d
+			},
+		},
+		{
+			// 不能调用 DecideN，它会真的等待
+			name: "漏桶",
+			decide: func(cost int64) Decision {
+				d, _ := lb.reserve("cost", cost)
+				return d
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := tc.decide(3)
+			assert.False(t, d.Limited)
+			assert.Equal(t, int64(2), d.Remaining)
+			// 剩下的配额不够，一个都不扣
+			d = tc.decide(3)
+			assert.True(t, d.Limited)
+			assert.Equal(t, int64(2), d.Remaining)
+			d = tc.decide(2)
+			assert.False(t, d.Limited)
+			assert.Equal(t, int64(0), d.Remaining)
+		})
+	}
+}
+
+func BenchmarkLocalLimiter(b *testing.B) {
+	limiters := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{name: "sliding_window", limiter: NewLocalSlidingWindowLimiter(time.Second, 1000)},
+		{name: "token_bucket", limiter: NewLocalTokenBucketLimiter(1000, time.Second, 1000)},
+		// 容量设置成 0，不排队，测的是预约本身的开销
+		{name: "leaky_bucket", limiter: NewLocalLeakyBucketLimiter(0, time.Second, 1000)},
+	}
+	for _, l := range limiters {
+		b.Run(l.name+"/single_key", func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, _ = l.limiter.Limit(context.Background(), "single")
+				}
+			})
+		})
+		b.Run(l.name+"/many_keys", func(b *testing.B) {
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = "key-" + strconv.Itoa(i)
+			}
+			var idx atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := idx.Add(1)
+					_, _ = l.limiter.Limit(context.Background(), keys[i%int64(len(keys))])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+var _ CostLimiter = &LocalSlidingWindowLimiter{}
+
+// LocalSlidingWindowLimiter 本地的滑动窗口限流器
+// 和 RedisSlidingWindowLimiter 一样，记录窗口内每一个请求的时间
+type LocalSlidingWindowLimiter struct {
+	// 窗口大小
+	interval time.Duration
+	// 阈值
+	rate  int
+	store *localStore[slidingWindow]
+}
+
+type slidingWindow struct {
+	// 窗口内请求的时间，按照时间先后排列
+	timestamps []int64
+}
+
+func NewLocalSlidingWindowLimiter(interval time.Duration, rate int,
+	opts ...option.Option[LocalOptions]) *LocalSlidingWindowLimiter {
+	o := defaultLocalOptions()
+	option.Apply[LocalOptions](&o, opts...)
+	return &LocalSlidingWindowLimiter{
+		interval: interval,
+		rate:     rate,
+		// 超过一个窗口没有请求，窗口里面就是空的
+		store: newLocalStore[slidingWindow](interval, o),
+	}
+}
+
+func (l *LocalSlidingWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.Decide(ctx, key)
+	return d.Limited, err
+}
+
+func (l *LocalSlidingWindowLimiter) Decide(ctx context.Context, key string) (Decision, error) {
+	return l.DecideN(ctx, key, 1)
+}
+
+func (l *LocalSlidingWindowLimiter) DecideN(ctx context.Context, key string, cost int64) (Decision, error) {
+	res := Decision{Limit: int64(l.rate)}
+	l.store.do(key, func(w *slidingWindow, now time.Time, fresh bool) {
+		nowNano := now.UnixNano()
+		min := nowNano - l.interval.Nanoseconds()
+		idx := 0
+		for idx < len(w.timestamps) && w.timestamps[idx] <= min {
+			idx++
+		}
+		w.timestamps = w.timestamps[idx:]
+		if int64(len(w.timestamps))+cost > int64(l.rate) {
+			res.Limited = true
+		} else {
+			// 容量不够的时候 append 只会复制还在窗口内的部分，过期的部分会被回收
+			for i := int64(0); i < cost; i++ {
+				w.timestamps = append(w.timestamps, nowNano)
+			}
+		}
+		res.Remaining = int64(l.rate - len(w.timestamps))
+		if n := len(w.timestamps); n > 0 {
+			// 最新的请求滑出窗口之后配额完全恢复
+			res.Reset = time.Duration(w.timestamps[n-1] + l.interval.Nanoseconds() - nowNano)
+			if res.Limited {
+				// 最早的请求滑出窗口之后就会空出一个位置
+				res.RetryAfter = time.Duration(w.timestamps[0] + l.interval.Nanoseconds() - nowNano)
+			}
+		}
+	})
+	return res, nil
+}
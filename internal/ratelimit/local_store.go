@@ -0,0 +1,192 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+// LocalOptions 本地限流器共用的配置
+type LocalOptions struct {
+	// 分片数量，分片越多锁竞争越小
+	Shards int
+	// 最多保存多少个 key 的状态，超过之后淘汰最久没有访问的 key。
+	// 用于在伪造 IP 之类的攻击下限制内存
+	MaxKeys int
+	nowFunc func() time.Time
+}
+
+func defaultLocalOptions() LocalOptions {
+	return LocalOptions{
+		Shards:  32,
+		MaxKeys: 100000,
+		nowFunc: time.Now,
+	}
+}
+
+// WithShards 设置分片数量
+func WithShards(shards int) option.Option[LocalOptions] {
+	return func(o *LocalOptions) {
+		o.Shards = shards
+	}
+}
+
+// WithMaxKeys 设置最多保存多少个 key 的状态
+func WithMaxKeys(maxKeys int) option.Option[LocalOptions] {
+	return func(o *LocalOptions) {
+		o.MaxKeys = maxKeys
+	}
+}
+
+// localStore 是分片的 key -> 状态的存储。
+// 每个分片内部按照访问时间维护 LRU 链表，
+// 在访问的时候顺便淘汰空闲超过 idleTimeout 的 key，以及超过容量的 key。
+// idleTimeout 应该是限流算法状态自然恢复到初始状态的时间，
+// 这样淘汰一个空闲的 key 不会改变限流的结果
+type localStore[T any] struct {
+	shards      []*localShard[T]
+	idleTimeout time.Duration
+	nowFunc     func() time.Time
+}
+
+type localShard[T any] struct {
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	lru     *list.List
+	maxKeys int
+}
+
+type localEntry[T any] struct {
+	key        string
+	state      T
+	lastAccess time.Time
+}
+
+func newLocalStore[T any](idleTimeout time.Duration, opts LocalOptions) *localStore[T] {
+	if opts.Shards <= 0 {
+		opts.Shards = 1
+	}
+	perShard := opts.MaxKeys / opts.Shards
+	if perShard <= 0 {
+		perShard = 1
+	}
+	res := &localStore[T]{
+		shards:      make([]*localShard[T], opts.Shards),
+		idleTimeout: idleTimeout,
+		nowFunc:     opts.nowFunc,
+	}
+	for i := range res.shards {
+		res.shards[i] = &localShard[T]{
+			items:   make(map[string]*list.Element),
+			lru:     list.New(),
+			maxKeys: perShard,
+		}
+	}
+	return res
+}
+
+// do 在持有分片锁的情况下执行 fn。
+// 如果 key 之前不存在（或者已经被淘汰），fresh 为 true，state 是零值
+func (s *localStore[T]) do(key string, fn func(state *T, now time.Time, fresh bool)) {
+	shard := s.shards[fnv32(key)%uint32(len(s.shards))]
+	now := s.nowFunc()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.evict(now, s.idleTimeout)
+
+	elem, ok := shard.items[key]
+	if !ok {
+		elem = shard.lru.PushFront(&localEntry[T]{key: key})
+		shard.items[key] = elem
+		if shard.lru.Len() > shard.maxKeys {
+			shard.remove(shard.lru.Back())
+		}
+	} else {
+		shard.lru.MoveToFront(elem)
+	}
+	entry := elem.Value.(*localEntry[T])
+	entry.lastAccess = now
+	fn(&entry.state, now, !ok)
+}
+
+// get 读取 key 的状态，和 do 不同，key 不存在的时候不会创建
+func (s *localStore[T]) get(key string) (T, bool) {
+	shard := s.shards[fnv32(key)%uint32(len(s.shards))]
+	now := s.nowFunc()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.evict(now, s.idleTimeout)
+	elem, ok := shard.items[key]
+	if !ok {
+		var t T
+		return t, false
+	}
+	return elem.Value.(*localEntry[T]).state, true
+}
+
+// del 删除 key 的状态
+func (s *localStore[T]) del(key string) {
+	shard := s.shards[fnv32(key)%uint32(len(s.shards))]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if elem, ok := shard.items[key]; ok {
+		shard.remove(elem)
+	}
+}
+
+// len 返回当前保存的 key 的数量
+func (s *localStore[T]) len() int {
+	var res int
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		res += shard.lru.Len()
+		shard.mu.Unlock()
+	}
+	return res
+}
+
+// evict 从链表尾部开始淘汰空闲的 key。
+// 每个 key 只会被淘汰一次，所以均摊下来是 O(1) 的
+func (s *localShard[T]) evict(now time.Time, idleTimeout time.Duration) {
+	for elem := s.lru.Back(); elem != nil; elem = s.lru.Back() {
+		if now.Sub(elem.Value.(*localEntry[T]).lastAccess) <= idleTimeout {
+			return
+		}
+		s.remove(elem)
+	}
+}
+
+func (s *localShard[T]) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.items, elem.Value.(*localEntry[T]).key)
+}
+
+// fnv32 是 FNV-1a 哈希，避免 hash/fnv 带来的内存分配
+func fnv32(key string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	hash := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock 用于在测试里面控制时间
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.UnixMilli(1695571200000)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) option() option.Option[LocalOptions] {
+	return func(o *LocalOptions) {
+		o.nowFunc = c.Now
+	}
+}
+
+func TestLocalStore_Evict(t *testing.T) {
+	clock := newFakeClock()
+	o := defaultLocalOptions()
+	o.Shards = 2
+	o.MaxKeys = 10
+	o.nowFunc = clock.Now
+	s := newLocalStore[int](time.Second, o)
+
+	inc := func(key string) (int, bool) {
+		var (
+			val   int
+			fresh bool
+		)
+		s.do(key, func(state *int, now time.Time, f bool) {
+			*state++
+			val = *state
+			fresh = f
+		})
+		return val, fresh
+	}
+
+	val, fresh := inc("a")
+	assert.Equal(t, 1, val)
+	assert.True(t, fresh)
+	val, fresh = inc("a")
+	assert.Equal(t, 2, val)
+	assert.False(t, fresh)
+
+	// 空闲超过 idleTimeout 之后被淘汰
+	clock.Add(2 * time.Second)
+	for i := 0; i < 4; i++ {
+		inc("b" + strconv.Itoa(i))
+	}
+	val, fresh = inc("a")
+	assert.Equal(t, 1, val)
+	assert.True(t, fresh)
+
+	// 不管有多少个 key，都不会超过 MaxKeys
+	for i := 0; i < 1000; i++ {
+		inc("flood" + strconv.Itoa(i))
+	}
+	assert.LessOrEqual(t, s.len(), 10)
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+var _ CostLimiter = &LocalTokenBucketLimiter{}
+
+// LocalTokenBucketLimiter 本地的令牌桶限流器
+type LocalTokenBucketLimiter struct {
+	// 桶的容量，也就是允许的最大突发请求数
+	capacity float64
+	// 每纳秒放入的令牌数
+	perNano float64
+	store   *localStore[tokenBucket]
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLocalTokenBucketLimiter 每 interval 放入 rate 个令牌，桶里最多有 capacity 个令牌。
+// capacity、interval 和 rate 都必须大于 0，否则 panic
+func NewLocalTokenBucketLimiter(capacity int, interval time.Duration, rate int,
+	opts ...option.Option[LocalOptions]) *LocalTokenBucketLimiter {
+	if err := checkTokenBucket(capacity, interval, rate); err != nil {
+		panic(err)
+	}
+	o := defaultLocalOptions()
+	option.Apply[LocalOptions](&o, opts...)
+	perNano := float64(rate) / float64(interval.Nanoseconds())
+	return &LocalTokenBucketLimiter{
+		capacity: float64(capacity),
+		perNano:  perNano,
+		// 桶从空到满需要的时间，超过这个时间没有请求，桶就是满的
+		store: newLocalStore[tokenBucket](
+			time.Duration(math.Ceil(float64(capacity)/perNano)), o),
+	}
+}
+
+func (l *LocalTokenBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.Decide(ctx, key)
+	return d.Limited, err
+}
+
+func (l *LocalTokenBucketLimiter) Decide(ctx context.Context, key string) (Decision, error) {
+	return l.DecideN(ctx, key, 1)
+}
+
+func (l *LocalTokenBucketLimiter) DecideN(ctx context.Context, key string, cost int64) (Decision, error) {
+	n := float64(cost)
+	res := Decision{Limit: int64(l.capacity)}
+	l.store.do(key, func(b *tokenBucket, now time.Time, fresh bool) {
+		if fresh {
+			b.tokens = l.capacity
+			b.last = now
+		}
+		if now.After(b.last) {
+			b.tokens = math.Min(l.capacity,
+				b.tokens+float64(now.Sub(b.last).Nanoseconds())*l.perNano)
+			b.last = now
+		}
+		if b.tokens < n {
+			res.Limited = true
+			// 攒够 cost 个令牌需要的时间
+			res.RetryAfter = l.duration(n - b.tokens)
+		} else {
+			b.tokens -= n
+		}
+		res.Remaining = int64(b.tokens)
+		res.Reset = l.duration(l.capacity - b.tokens)
+	})
+	return res, nil
+}
+
+// duration 计算放入 tokens 个令牌需要的时间
+func (l *LocalTokenBucketLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.perNano))
+}
+
+// checkTokenBucket 参数不是正数的时候，计算令牌的速度会除以 0
+func checkTokenBucket(capacity int, interval time.Duration, rate int) error {
+	if capacity <= 0 || interval <= 0 || rate <= 0 {
+		return fmt.Errorf("令牌桶的参数必须大于 0: capacity=%d, interval=%s, rate=%d",
+			capacity, interval, rate)
+	}
+	return nil
+}
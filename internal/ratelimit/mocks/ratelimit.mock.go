@@ -11,7 +11,9 @@ package limitmocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
+	ratelimit "github.com/ecodeclub/ginx/internal/ratelimit"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -52,3 +54,159 @@ func (mr *MockLimiterMockRecorder) Limit(ctx, key any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Limit", reflect.TypeOf((*MockLimiter)(nil).Limit), ctx, key)
 }
+
+// MockDecisionLimiter is a mock of DecisionLimiter interface.
+type MockDecisionLimiter struct {
+	ctrl     *gomock.Controller
+	recorder *MockDecisionLimiterMockRecorder
+}
+
+// MockDecisionLimiterMockRecorder is the mock recorder for MockDecisionLimiter.
+type MockDecisionLimiterMockRecorder struct {
+	mock *MockDecisionLimiter
+}
+
+// NewMockDecisionLimiter creates a new mock instance.
+func NewMockDecisionLimiter(ctrl *gomock.Controller) *MockDecisionLimiter {
+	mock := &MockDecisionLimiter{ctrl: ctrl}
+	mock.recorder = &MockDecisionLimiterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDecisionLimiter) EXPECT() *MockDecisionLimiterMockRecorder {
+	return m.recorder
+}
+
+// Decide mocks base method.
+func (m *MockDecisionLimiter) Decide(ctx context.Context, key string) (ratelimit.Decision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Decide", ctx, key)
+	ret0, _ := ret[0].(ratelimit.Decision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Decide indicates an expected call of Decide.
+func (mr *MockDecisionLimiterMockRecorder) Decide(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decide", reflect.TypeOf((*MockDecisionLimiter)(nil).Decide), ctx, key)
+}
+
+// Limit mocks base method.
+func (m *MockDecisionLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Limit", ctx, key)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Limit indicates an expected call of Limit.
+func (mr *MockDecisionLimiterMockRecorder) Limit(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Limit", reflect.TypeOf((*MockDecisionLimiter)(nil).Limit), ctx, key)
+}
+
+// MockCostLimiter is a mock of CostLimiter interface.
+type MockCostLimiter struct {
+	ctrl     *gomock.Controller
+	recorder *MockCostLimiterMockRecorder
+}
+
+// MockCostLimiterMockRecorder is the mock recorder for MockCostLimiter.
+type MockCostLimiterMockRecorder struct {
+	mock *MockCostLimiter
+}
+
+// NewMockCostLimiter creates a new mock instance.
+func NewMockCostLimiter(ctrl *gomock.Controller) *MockCostLimiter {
+	mock := &MockCostLimiter{ctrl: ctrl}
+	mock.recorder = &MockCostLimiterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCostLimiter) EXPECT() *MockCostLimiterMockRecorder {
+	return m.recorder
+}
+
+// Decide mocks base method.
+func (m *MockCostLimiter) Decide(ctx context.Context, key string) (ratelimit.Decision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Decide", ctx, key)
+	ret0, _ := ret[0].(ratelimit.Decision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Decide indicates an expected call of Decide.
+func (mr *MockCostLimiterMockRecorder) Decide(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decide", reflect.TypeOf((*MockCostLimiter)(nil).Decide), ctx, key)
+}
+
+// DecideN mocks base method.
+func (m *MockCostLimiter) DecideN(ctx context.Context, key string, cost int64) (ratelimit.Decision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecideN", ctx, key, cost)
+	ret0, _ := ret[0].(ratelimit.Decision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecideN indicates an expected call of DecideN.
+func (mr *MockCostLimiterMockRecorder) DecideN(ctx, key, cost any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecideN", reflect.TypeOf((*MockCostLimiter)(nil).DecideN), ctx, key, cost)
+}
+
+// Limit mocks base method.
+func (m *MockCostLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Limit", ctx, key)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Limit indicates an expected call of Limit.
+func (mr *MockCostLimiterMockRecorder) Limit(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Limit", reflect.TypeOf((*MockCostLimiter)(nil).Limit), ctx, key)
+}
+
+// MockReconfigurable is a mock of Reconfigurable interface.
+type MockReconfigurable struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconfigurableMockRecorder
+}
+
+// MockReconfigurableMockRecorder is the mock recorder for MockReconfigurable.
+type MockReconfigurableMockRecorder struct {
+	mock *MockReconfigurable
+}
+
+// NewMockReconfigurable creates a new mock instance.
+func NewMockReconfigurable(ctrl *gomock.Controller) *MockReconfigurable {
+	mock := &MockReconfigurable{ctrl: ctrl}
+	mock.recorder = &MockReconfigurableMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconfigurable) EXPECT() *MockReconfigurableMockRecorder {
+	return m.recorder
+}
+
+// SetLimit mocks base method.
+func (m *MockReconfigurable) SetLimit(interval time.Duration, rate int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetLimit", interval, rate)
+}
+
+// SetLimit indicates an expected call of SetLimit.
+func (mr *MockReconfigurableMockRecorder) SetLimit(interval, rate any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLimit", reflect.TypeOf((*MockReconfigurable)(nil).SetLimit), interval, rate)
+}
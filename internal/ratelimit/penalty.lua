@@ -0,0 +1,39 @@
+-- 封禁的标记
+local banKey = KEYS[1]
+-- 违规次数
+local violationKey = KEYS[2]
+-- 封禁的等级，每封禁一次加一，封禁的时间随着等级翻倍
+local levelKey = KEYS[3]
+-- window 毫秒内违规 threshold 次就封禁
+local threshold = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+-- 第一次封禁的时间以及最长的封禁时间
+local baseBan = tonumber(ARGV[3])
+local maxBan = tonumber(ARGV[4])
+-- 多久没有被封禁，等级就清零
+local decay = tonumber(ARGV[5])
+
+local ttl = redis.call('PTTL', banKey)
+if ttl > 0 then
+    -- 已经被封禁了，可能是别的实例封禁的
+    return { 0, ttl, 0 }
+end
+
+local cnt = redis.call('INCR', violationKey)
+if cnt == 1 then
+    redis.call('PEXPIRE', violationKey, window)
+end
+if cnt < threshold then
+    return { 0, 0, 0 }
+end
+
+redis.call('DEL', violationKey)
+local level = redis.call('INCR', levelKey)
+redis.call('PEXPIRE', levelKey, decay)
+local ban = baseBan * math.pow(2, level - 1)
+if ban > maxBan then
+    ban = maxBan
+end
+redis.call('SET', banKey, level, 'PX', ban)
+-- 返回: 是否这一次封禁的, 封禁的毫秒数, 封禁的等级
+return { 1, ban, level }
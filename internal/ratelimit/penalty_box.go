@@ -0,0 +1,228 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed penalty.lua
+var luaPenalty string
+
+// PenaltyBox 对频繁触发限流的 key 进行封禁。
+// window 内被限流 threshold 次之后封禁，封禁的时间从 baseBan 开始，每次翻倍，最长 maxBan。
+// 封禁记录在 Redis 里面，同时缓存在本地，被封禁的请求不需要访问 Redis。
+// 本地缓存默认一直保存到封禁结束，所以 Lift 只会立刻在当前实例上生效；
+// 设置了 localTTL 之后，本地缓存超过 localTTL 就会重新到 Redis 确认，Lift 最多 localTTL 之后在其它实例上生效。
+// 没有被封禁的结果也会在本地缓存 negativeTTL，避免正常的请求每次都多访问一次 Redis
+type PenaltyBox struct {
+	cmd       redis.Cmdable
+	prefix    string
+	threshold int
+	window    time.Duration
+	baseBan   time.Duration
+	maxBan    time.Duration
+	decay     time.Duration
+	localTTL  time.Duration
+	// 没有被封禁的结果在本地缓存多久
+	negativeTTL time.Duration
+	onBan       func(key string, ban time.Duration, level int64)
+
+	local   LocalOptions
+	banned  *localStore[localBan]
+	nowFunc func() time.Time
+}
+
+type localBan struct {
+	// 封禁结束的时间，零值表示没有被封禁
+	until time.Time
+	// 本地缓存失效的时间
+	expireAt time.Time
+}
+
+// NewPenaltyBox 默认 1 分钟内被限流 10 次就封禁，封禁时间从 1 分钟开始翻倍，最长 1 天，
+// 一天内没有再被封禁，封禁时间就从头开始算。没有被封禁的结果在本地缓存 1 秒
+func NewPenaltyBox(cmd redis.Cmdable, opts ...option.Option[PenaltyBox]) *PenaltyBox {
+	res := &PenaltyBox{
+		cmd:         cmd,
+		prefix:      "penalty",
+		threshold:   10,
+		window:      time.Minute,
+		baseBan:     time.Minute,
+		maxBan:      24 * time.Hour,
+		decay:       24 * time.Hour,
+		negativeTTL: time.Second,
+		onBan:       func(key string, ban time.Duration, level int64) {},
+		local:       defaultLocalOptions(),
+	}
+	option.Apply[PenaltyBox](res, opts...)
+	res.nowFunc = res.local.nowFunc
+	// 封禁的时间不会超过 maxBan，所以空闲超过 maxBan 的记录一定已经过期了
+	res.banned = newLocalStore[localBan](res.maxBan, res.local)
+	return res
+}
+
+// WithPenaltyThreshold window 内被限流 threshold 次之后封禁
+func WithPenaltyThreshold(threshold int, window time.Duration) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		p.threshold = threshold
+		p.window = window
+	}
+}
+
+// WithPenaltyBan 第一次封禁 baseBan，之后每次翻倍，最长 maxBan
+func WithPenaltyBan(baseBan, maxBan time.Duration) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		p.baseBan = baseBan
+		p.maxBan = maxBan
+	}
+}
+
+// WithPenaltyDecay 超过 decay 没有被封禁，封禁时间就从 baseBan 重新开始
+func WithPenaltyDecay(decay time.Duration) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		p.decay = decay
+	}
+}
+
+// WithPenaltyLocalTTL 本地缓存封禁记录的最长时间，小于等于 0 表示一直缓存到封禁结束
+func WithPenaltyLocalTTL(ttl time.Duration) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		p.localTTL = ttl
+	}
+}
+
+// WithPenaltyNegativeTTL 本地缓存没有被封禁的结果的时间，也就是别的实例上的封禁在当前实例上生效的最长延迟。
+// 小于等于 0 表示不缓存，每次都到 Redis 确认
+func WithPenaltyNegativeTTL(ttl time.Duration) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		p.negativeTTL = ttl
+	}
+}
+
+// WithPenaltyHook 封禁的时候回调，level 是第几次封禁
+func WithPenaltyHook(fn func(key string, ban time.Duration, level int64)) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		p.onBan = fn
+	}
+}
+
+// WithPenaltyLocalOptions 设置本地缓存的分片和容量
+func WithPenaltyLocalOptions(opts ...option.Option[LocalOptions]) option.Option[PenaltyBox] {
+	return func(p *PenaltyBox) {
+		option.Apply[LocalOptions](&p.local, opts...)
+	}
+}
+
+// Banned 返回还要封禁多久，0 表示没有被封禁。
+// 本地缓存命中的时候不会访问 Redis，否则到 Redis 查询，这样别的实例封禁的 key 也能生效
+func (p *PenaltyBox) Banned(ctx context.Context, key string) (time.Duration, error) {
+	if left, ok := p.Cached(key); ok {
+		return left, nil
+	}
+	ttl, err := p.cmd.PTTL(ctx, p.keys(key)[0]).Result()
+	if err != nil {
+		return 0, err
+	}
+	// 不存在的 key 返回的是负数
+	if ttl <= 0 {
+		p.cacheNotBanned(key)
+		return 0, nil
+	}
+	p.cache(key, ttl)
+	return ttl, nil
+}
+
+// Cached 只查询本地缓存，ok 为 false 表示需要到 Redis 确认
+func (p *PenaltyBox) Cached(key string) (left time.Duration, ok bool) {
+	ban, ok := p.banned.get(key)
+	if !ok {
+		return 0, false
+	}
+	now := p.nowFunc()
+	if now.After(ban.expireAt) {
+		p.banned.del(key)
+		return 0, false
+	}
+	if ban.until.IsZero() {
+		return 0, true
+	}
+	left = ban.until.Sub(now)
+	if left <= 0 {
+		p.banned.del(key)
+		return 0, false
+	}
+	return left, true
+}
+
+// Violate 记录一次限流。如果因此被封禁，或者已经被别的实例封禁了，返回封禁的时间
+func (p *PenaltyBox) Violate(ctx context.Context, key string) (time.Duration, error) {
+	vals, err := p.cmd.Eval(ctx, luaPenalty, p.keys(key), p.threshold, p.window.Milliseconds(),
+		p.baseBan.Milliseconds(), p.maxBan.Milliseconds(), p.decay.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if len(vals) != 3 {
+		return 0, fmt.Errorf("封禁脚本返回值错误: %v", vals)
+	}
+	ban := time.Duration(vals[1]) * time.Millisecond
+	if ban <= 0 {
+		return 0, nil
+	}
+	p.cache(key, ban)
+	if vals[0] == 1 {
+		p.onBan(key, ban, vals[2])
+	}
+	return ban, nil
+}
+
+// Lift 解除封禁，同时清空违规次数和封禁等级。
+// 其它实例的本地缓存要等到封禁结束，或者 localTTL 之后才会失效
+func (p *PenaltyBox) Lift(ctx context.Context, key string) error {
+	p.banned.del(key)
+	return p.cmd.Del(ctx, p.keys(key)...).Err()
+}
+
+func (p *PenaltyBox) cache(key string, ban time.Duration) {
+	p.banned.do(key, func(b *localBan, now time.Time, fresh bool) {
+		b.until = now.Add(ban)
+		b.expireAt = b.until
+		if p.localTTL > 0 && p.localTTL < ban {
+			b.expireAt = now.Add(p.localTTL)
+		}
+	})
+}
+
+func (p *PenaltyBox) cacheNotBanned(key string) {
+	if p.negativeTTL <= 0 {
+		return
+	}
+	p.banned.do(key, func(b *localBan, now time.Time, fresh bool) {
+		b.until = time.Time{}
+		b.expireAt = now.Add(p.negativeTTL)
+	})
+}
+
+func (p *PenaltyBox) keys(key string) []string {
+	// 使用 hash tag，保证在集群模式下三个 key 在同一个 slot
+	prefix := p.prefix + ":{" + key + "}:"
+	return []string{prefix + "ban", prefix + "violation", prefix + "level"}
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build e2e
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPenaltyBox(t *testing.T) {
+	var levels []int64
+	p := NewPenaltyBox(initRedis(),
+		WithPenaltyThreshold(2, time.Second),
+		WithPenaltyBan(200*time.Millisecond, 300*time.Millisecond),
+		WithPenaltyHook(func(key string, ban time.Duration, level int64) {
+			levels = append(levels, level)
+		}))
+	ctx := context.Background()
+	key := "TestPenaltyBox"
+	require.NoError(t, p.Lift(ctx, key))
+
+	ban, err := p.Violate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ban)
+	left, err := p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), left)
+
+	// 第二次违规，封禁
+	ban, err = p.Violate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, 200*time.Millisecond, ban)
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Greater(t, left, time.Duration(0))
+	assert.LessOrEqual(t, left, 200*time.Millisecond)
+
+	// 已经被封禁了，返回剩下的时间，不会重复封禁
+	ban, err = p.Violate(ctx, key)
+	require.NoError(t, err)
+	assert.Greater(t, ban, time.Duration(0))
+	assert.LessOrEqual(t, ban, 200*time.Millisecond)
+	assert.Equal(t, []int64{1}, levels)
+
+	// 封禁结束之后再次被封禁，时间翻倍，但是不超过最大值
+	time.Sleep(250 * time.Millisecond)
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), left)
+	_, err = p.Violate(ctx, key)
+	require.NoError(t, err)
+	ban, err = p.Violate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, 300*time.Millisecond, ban)
+	assert.Equal(t, []int64{1, 2}, levels)
+
+	// 解除封禁
+	require.NoError(t, p.Lift(ctx, key))
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), left)
+	ban, err = p.Violate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ban)
+}
+
+func TestPenaltyBox_Banned(t *testing.T) {
+	cmd := initRedis()
+	opts := []option.Option[PenaltyBox]{
+		WithPenaltyThreshold(1, time.Second),
+		WithPenaltyBan(time.Minute, time.Minute),
+	}
+	ctx := context.Background()
+	key := "TestPenaltyBox_Banned"
+
+	var ok bool
+	// 别的实例封禁的 key
+	other := NewPenaltyBox(cmd, opts...)
+	require.NoError(t, other.Lift(ctx, key))
+	ban, err := other.Violate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, ban)
+	p := NewPenaltyBox(cmd, append(opts, WithPenaltyLocalTTL(100*time.Millisecond))...)
+	left, err := p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Greater(t, left, 59*time.Second)
+
+	// 默认一直缓存到封禁结束
+	q := NewPenaltyBox(cmd, opts...)
+	_, err = q.Banned(ctx, key)
+	require.NoError(t, err)
+
+	// 本地缓存过期之后，到 Redis 确认还在封禁
+	time.Sleep(150 * time.Millisecond)
+	_, ok = q.Cached(key)
+	assert.True(t, ok)
+	_, ok = p.Cached(key)
+	assert.False(t, ok)
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Greater(t, left, 59*time.Second)
+
+	// 设置了 localTTL 的实例，Lift 之后会重新确认
+	require.NoError(t, other.Lift(ctx, key))
+	time.Sleep(150 * time.Millisecond)
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), left)
+}
+
+func TestPenaltyBox_NegativeTTL(t *testing.T) {
+	cmd := initRedis()
+	opts := []option.Option[PenaltyBox]{
+		WithPenaltyThreshold(1, time.Second),
+		WithPenaltyBan(time.Minute, time.Minute),
+	}
+	ctx := context.Background()
+	key := "TestPenaltyBox_NegativeTTL"
+
+	other := NewPenaltyBox(cmd, opts...)
+	require.NoError(t, other.Lift(ctx, key))
+	p := NewPenaltyBox(cmd, append(opts, WithPenaltyNegativeTTL(100*time.Millisecond))...)
+	left, err := p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), left)
+	left, ok := p.Cached(key)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), left)
+
+	// 别的实例封禁之后，要等没有封禁的缓存过期才会生效
+	_, err = other.Violate(ctx, key)
+	require.NoError(t, err)
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), left)
+	time.Sleep(150 * time.Millisecond)
+	_, ok = p.Cached(key)
+	assert.False(t, ok)
+	left, err = p.Banned(ctx, key)
+	require.NoError(t, err)
+	assert.Greater(t, left, 59*time.Second)
+
+	// 不缓存没有封禁的结果
+	q := NewPenaltyBox(cmd, append(opts, WithPenaltyNegativeTTL(0))...)
+	require.NoError(t, other.Lift(ctx, key))
+	_, err = q.Banned(ctx, key)
+	require.NoError(t, err)
+	_, ok = q.Cached(key)
+	assert.False(t, ok)
+}
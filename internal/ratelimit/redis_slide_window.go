@@ -18,6 +18,9 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,6 +30,13 @@ import (
 //go:embed slide_window.lua
 var luaSlideWindow string
 
+var (
+	// ZSET 的 member 需要唯一，用实例 ID 加上自增序号就可以了，
+	// 不需要每个请求都生成一个 UUID
+	instanceID = uuid.NewString()
+	memberSeq  atomic.Uint64
+)
+
 // RedisSlidingWindowLimiter Redis 上的滑动窗口算法限流器实现
 type RedisSlidingWindowLimiter struct {
 	Cmd redis.Cmdable
@@ -37,13 +47,54 @@ type RedisSlidingWindowLimiter struct {
 	Rate int
 	// Interval 内允许 Rate 个请求
 	// 1s 内允许 3000 个请求
+
+	// 保护 Interval 和 Rate，创建之后只能通过 SetLimit 修改
+	mu sync.RWMutex
+}
+
+var (
+	_ CostLimiter    = &RedisSlidingWindowLimiter{}
+	_ Reconfigurable = &RedisSlidingWindowLimiter{}
+)
+
+func (r *RedisSlidingWindowLimiter) SetLimit(interval time.Duration, rate int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Interval = interval
+	r.Rate = rate
+}
+
+func (r *RedisSlidingWindowLimiter) limit() (time.Duration, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.Interval, r.Rate
 }
 
 func (r *RedisSlidingWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
-	uid, err := uuid.NewUUID()
+	d, err := r.Decide(ctx, key)
+	return d.Limited, err
+}
+
+func (r *RedisSlidingWindowLimiter) Decide(ctx context.Context, key string) (Decision, error) {
+	return r.DecideN(ctx, key, 1)
+}
+
+func (r *RedisSlidingWindowLimiter) DecideN(ctx context.Context, key string, cost int64) (Decision, error) {
+	interval, rate := r.limit()
+	member := instanceID + ":" + strconv.FormatUint(memberSeq.Add(1), 36)
+	vals, err := r.Cmd.Eval(ctx, luaSlideWindow, []string{key},
+		interval.Milliseconds(), rate, time.Now().UnixMilli(), member, cost).Int64Slice()
 	if err != nil {
-		return false, fmt.Errorf("generate uuid failed: %w", err)
+		return Decision{}, err
+	}
+	if len(vals) != 4 {
+		return Decision{}, fmt.Errorf("滑动窗口脚本返回值错误: %v", vals)
 	}
-	return r.Cmd.Eval(ctx, luaSlideWindow, []string{key},
-		r.Interval.Milliseconds(), r.Rate, time.Now().UnixMilli(), uid.String()).Bool()
+	return Decision{
+		Limited:    vals[0] == 1,
+		Limit:      int64(rate),
+		Remaining:  vals[1],
+		Reset:      time.Duration(vals[2]) * time.Millisecond,
+		RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+	}, nil
 }
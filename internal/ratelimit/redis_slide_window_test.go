@@ -23,6 +23,7 @@ import (
 
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRedisSlidingWindowLimiter_Limit(t *testing.T) {
@@ -76,6 +77,59 @@ func TestRedisSlidingWindowLimiter_Limit(t *testing.T) {
 	}
 }
 
+func TestRedisSlidingWindowLimiter_SetLimit(t *testing.T) {
+	r := &RedisSlidingWindowLimiter{
+		Cmd:      initRedis(),
+		Interval: time.Minute,
+		Rate:     1,
+	}
+	ctx := context.Background()
+	key := "TestRedisSlidingWindowLimiter_SetLimit"
+	require.NoError(t, r.Cmd.Del(ctx, key).Err())
+	d, err := r.Decide(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, d.Limited)
+	d, err = r.Decide(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+
+	// 调大之后已经记录的请求还在
+	r.SetLimit(time.Minute, 2)
+	d, err = r.Decide(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, d.Limited)
+	assert.Equal(t, int64(2), d.Limit)
+	assert.Equal(t, int64(0), d.Remaining)
+}
+
+func TestRedisSlidingWindowLimiter_Decide(t *testing.T) {
+	r := &RedisSlidingWindowLimiter{
+		Cmd:      initRedis(),
+		Interval: time.Second,
+		Rate:     2,
+	}
+	key := "TestRedisSlidingWindowLimiter_Decide"
+	require.NoError(t, r.Cmd.Del(context.Background(), key).Err())
+
+	d, err := r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.False(t, d.Limited)
+	assert.Equal(t, int64(2), d.Limit)
+	assert.Equal(t, int64(1), d.Remaining)
+	assert.InDelta(t, time.Second, d.Reset, float64(50*time.Millisecond))
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	d, err = r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+	assert.Equal(t, int64(0), d.Remaining)
+	// 最新的请求滑出窗口之后完全恢复，最早的请求滑出窗口之后就可以重试
+	assert.InDelta(t, time.Second, d.Reset, float64(50*time.Millisecond))
+	assert.InDelta(t, 700*time.Millisecond, d.RetryAfter, float64(50*time.Millisecond))
+}
+
 func initRedis() redis.Cmdable {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: "localhost:16379",
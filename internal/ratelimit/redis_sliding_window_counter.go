@@ -0,0 +1,90 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed sliding_window_counter.lua
+var luaSlidingWindowCounter string
+
+// RedisSlidingWindowCounterLimiter 基于 Redis 的滑动窗口计数器限流器
+// 和 RedisSlidingWindowLimiter 不同，它不记录每一个请求，
+// 而是只保存当前和上一个固定窗口的计数，按照上一个窗口还在滑动窗口内的比例估算请求数。
+// 所以每个 key 的内存占用是 O(1) 的，代价是结果是近似的
+type RedisSlidingWindowCounterLimiter struct {
+	Cmd redis.Cmdable
+
+	// 窗口大小
+	Interval time.Duration
+	// 阈值
+	Rate int
+
+	// 保护 Interval 和 Rate，创建之后只能通过 SetLimit 修改
+	mu sync.RWMutex
+}
+
+var (
+	_ CostLimiter    = &RedisSlidingWindowCounterLimiter{}
+	_ Reconfigurable = &RedisSlidingWindowCounterLimiter{}
+)
+
+func (r *RedisSlidingWindowCounterLimiter) SetLimit(interval time.Duration, rate int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Interval = interval
+	r.Rate = rate
+}
+
+func (r *RedisSlidingWindowCounterLimiter) limit() (time.Duration, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.Interval, r.Rate
+}
+
+func (r *RedisSlidingWindowCounterLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := r.Decide(ctx, key)
+	return d.Limited, err
+}
+
+func (r *RedisSlidingWindowCounterLimiter) Decide(ctx context.Context, key string) (Decision, error) {
+	return r.DecideN(ctx, key, 1)
+}
+
+func (r *RedisSlidingWindowCounterLimiter) DecideN(ctx context.Context, key string, cost int64) (Decision, error) {
+	interval, rate := r.limit()
+	vals, err := r.Cmd.Eval(ctx, luaSlidingWindowCounter, []string{key},
+		interval.Milliseconds(), rate, time.Now().UnixMilli(), cost).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(vals) != 4 {
+		return Decision{}, fmt.Errorf("滑动窗口计数器脚本返回值错误: %v", vals)
+	}
+	return Decision{
+		Limited:    vals[0] == 1,
+		Limit:      int64(rate),
+		Remaining:  vals[1],
+		Reset:      time.Duration(vals[2]) * time.Millisecond,
+		RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build e2e
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisSlidingWindowCounterLimiter_Limit(t *testing.T) {
+	r := &RedisSlidingWindowCounterLimiter{
+		Cmd:      initRedis(),
+		Interval: time.Second,
+		Rate:     3,
+	}
+	key := "TestRedisSlidingWindowCounterLimiter_Limit"
+	require.NoError(t, r.Cmd.Del(context.Background(), key).Err())
+	// 对齐到窗口的开始，避免测试过程中跨越窗口
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second + 10*time.Millisecond)))
+
+	for i := 0; i < 3; i++ {
+		d, err := r.Decide(context.Background(), key)
+		require.NoError(t, err)
+		assert.False(t, d.Limited)
+		assert.Equal(t, int64(2-i), d.Remaining)
+	}
+	d, err := r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+	assert.Greater(t, d.RetryAfter, time.Duration(0))
+	assert.LessOrEqual(t, d.RetryAfter, time.Second)
+
+	// 进入下一个窗口的一半，上一个窗口的 3 个请求按照比例估算还剩 1.5 个
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second + 500*time.Millisecond)))
+	d, err = r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.False(t, d.Limited)
+	d, err = r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+
+	// 每个 key 只有一个 hash，大小是固定的
+	typ, err := r.Cmd.Type(context.Background(), key).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "hash", typ)
+	n, err := r.Cmd.HLen(context.Background(), key).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+func TestRedisLimiter_DecideN(t *testing.T) {
+	cmd := initRedis()
+	testCases := []struct {
+		name    string
+		limiter CostLimiter
+	}{
+		{
+			name:    "滑动窗口",
+			limiter: &RedisSlidingWindowLimiter{Cmd: cmd, Interval: time.Minute, Rate: 5},
+		},
+		{
+			name:    "滑动窗口计数器",
+			limiter: &RedisSlidingWindowCounterLimiter{Cmd: cmd, Interval: time.Hour, Rate: 5},
+		},
+		{
+			name:    "令牌桶",
+			limiter: &RedisTokenBucketLimiter{Cmd: cmd, Capacity: 5, Interval: time.Hour, Rate: 5},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := "TestRedisLimiter_DecideN_" + tc.name
+			require.NoError(t, cmd.Del(context.Background(), key).Err())
+			d, err := tc.limiter.DecideN(context.Background(), key, 3)
+			require.NoError(t, err)
+			assert.False(t, d.Limited)
+			assert.Equal(t, int64(2), d.Remaining)
+			// 剩下的配额不够，一个都不扣
+			d, err = tc.limiter.DecideN(context.Background(), key, 3)
+			require.NoError(t, err)
+			assert.True(t, d.Limited)
+			assert.Equal(t, int64(2), d.Remaining)
+			d, err = tc.limiter.DecideN(context.Background(), key, 2)
+			require.NoError(t, err)
+			assert.False(t, d.Limited)
+			assert.Equal(t, int64(0), d.Remaining)
+		})
+	}
+}
+
+func BenchmarkRedisSlidingWindow(b *testing.B) {
+	limiters := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{
+			name: "zset",
+			limiter: &RedisSlidingWindowLimiter{
+				Cmd:      initRedis(),
+				Interval: time.Second,
+				Rate:     100000,
+			},
+		},
+		{
+			name: "counter",
+			limiter: &RedisSlidingWindowCounterLimiter{
+				Cmd:      initRedis(),
+				Interval: time.Second,
+				Rate:     100000,
+			},
+		},
+	}
+	for _, l := range limiters {
+		b.Run(l.name, func(b *testing.B) {
+			key := "BenchmarkRedisSlidingWindow_" + l.name
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = l.limiter.Limit(context.Background(), key)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed token_bucket.lua
+var luaTokenBucket string
+
+// RedisTokenBucketLimiter 基于 Redis 的令牌桶限流器
+// 每个 key 只保存令牌数和上一次更新的时间，所以内存占用是 O(1) 的
+type RedisTokenBucketLimiter struct {
+	Cmd redis.Cmdable
+
+	// 桶的容量，也就是允许的最大突发请求数
+	Capacity int
+	// 每 Interval 放入 Rate 个令牌
+	// 例如每秒 100 个请求，允许突发到 300 个请求：
+	// Capacity = 300, Interval = 1s, Rate = 100
+	// 三个参数都必须大于 0，否则返回错误
+	Interval time.Duration
+	Rate     int
+}
+
+var _ CostLimiter = &RedisTokenBucketLimiter{}
+
+// NewRedisTokenBucketLimiter capacity、interval 和 rate 都必须大于 0，否则 panic
+func NewRedisTokenBucketLimiter(cmd redis.Cmdable, capacity int,
+	interval time.Duration, rate int) *RedisTokenBucketLimiter {
+	if err := checkTokenBucket(capacity, interval, rate); err != nil {
+		panic(err)
+	}
+	return &RedisTokenBucketLimiter{
+		Cmd:      cmd,
+		Capacity: capacity,
+		Interval: interval,
+		Rate:     rate,
+	}
+}
+
+func (r *RedisTokenBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := r.Decide(ctx, key)
+	return d.Limited, err
+}
+
+func (r *RedisTokenBucketLimiter) Decide(ctx context.Context, key string) (Decision, error) {
+	return r.DecideN(ctx, key, 1)
+}
+
+func (r *RedisTokenBucketLimiter) DecideN(ctx context.Context, key string, cost int64) (Decision, error) {
+	if err := checkTokenBucket(r.Capacity, r.Interval, r.Rate); err != nil {
+		return Decision{}, err
+	}
+	vals, err := r.Cmd.Eval(ctx, luaTokenBucket, []string{key},
+		r.Capacity, r.Rate, r.Interval.Milliseconds(), time.Now().UnixMilli(), cost).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(vals) != 4 {
+		return Decision{}, fmt.Errorf("令牌桶脚本返回值错误: %v", vals)
+	}
+	return Decision{
+		Limited:    vals[0] == 1,
+		Limit:      int64(r.Capacity),
+		Remaining:  vals[1],
+		Reset:      time.Duration(vals[2]) * time.Millisecond,
+		RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+	}, nil
+}
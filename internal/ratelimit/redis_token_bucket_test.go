@@ -0,0 +1,139 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build e2e
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisTokenBucketLimiter_Limit(t *testing.T) {
+	r := &RedisTokenBucketLimiter{
+		Cmd:      initRedis(),
+		Capacity: 2,
+		Interval: 500 * time.Millisecond,
+		Rate:     1,
+	}
+	key := "TestRedisTokenBucketLimiter_Limit"
+	require.NoError(t, r.Cmd.Del(context.Background(), key, key+"_other").Err())
+	tests := []struct {
+		name     string
+		key      string
+		interval time.Duration
+		want     bool
+	}{
+		{
+			name: "桶是满的，正常通过",
+			key:  key,
+			want: false,
+		},
+		{
+			name: "突发请求，正常通过",
+			key:  key,
+			want: false,
+		},
+		{
+			name: "另外一个key正常通过",
+			key:  key + "_other",
+			want: false,
+		},
+		{
+			name: "令牌用完，限流",
+			key:  key,
+			want: true,
+		},
+		{
+			name:     "放入了一个令牌，正常通过",
+			key:      key,
+			interval: 510 * time.Millisecond,
+			want:     false,
+		},
+		{
+			name: "令牌又用完了，限流",
+			key:  key,
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			<-time.After(tt.interval)
+			got, err := r.Limit(context.Background(), tt.key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRedisTokenBucketLimiter_Decide(t *testing.T) {
+	r := &RedisTokenBucketLimiter{
+		Cmd:      initRedis(),
+		Capacity: 2,
+		Interval: time.Second,
+		Rate:     10,
+	}
+	key := "TestRedisTokenBucketLimiter_Decide"
+	require.NoError(t, r.Cmd.Del(context.Background(), key).Err())
+
+	d, err := r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.False(t, d.Limited)
+	assert.Equal(t, int64(2), d.Limit)
+	assert.Equal(t, int64(1), d.Remaining)
+	assert.InDelta(t, 100*time.Millisecond, d.Reset, float64(10*time.Millisecond))
+
+	_, err = r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	d, err = r.Decide(context.Background(), key)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+	assert.Equal(t, int64(0), d.Remaining)
+	// 攒够一个令牌最多需要 100ms
+	assert.Greater(t, d.RetryAfter, time.Duration(0))
+	assert.LessOrEqual(t, d.RetryAfter, 100*time.Millisecond)
+}
+
+func TestRedisTokenBucketLimiter_Burst(t *testing.T) {
+	r := &RedisTokenBucketLimiter{
+		Cmd:      initRedis(),
+		Capacity: 300,
+		Interval: time.Second,
+		Rate:     100,
+	}
+	key := "TestRedisTokenBucketLimiter_Burst"
+	require.NoError(t, r.Cmd.Del(context.Background(), key).Err())
+	var succCount int
+	start := time.Now()
+	for i := 0; i < 500; i++ {
+		limited, err := r.Limit(context.Background(), key)
+		require.NoError(t, err)
+		if !limited {
+			succCount++
+		}
+	}
+	// 突发的 300 个加上这段时间内补充的令牌
+	maxSucc := 300 + int(time.Since(start).Seconds()*100) + 1
+	assert.GreaterOrEqual(t, succCount, 300)
+	assert.LessOrEqual(t, succCount, maxSucc)
+	// 每个 key 只有一个 hash
+	typ, err := r.Cmd.Type(context.Background(), key).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "hash", typ)
+}
@@ -8,18 +8,40 @@ local now = tonumber(ARGV[3])
 -- 唯一ID, 用于解决同一时间内多个请求只统计一次的问题
 -- SEE: issue #27
 local uid = ARGV[4]
+-- 这次请求要消耗多少个配额
+local cost = tonumber(ARGV[5])
 -- 窗口的起始时间
 local min = now - window
 
 redis.call('ZREMRANGEBYSCORE', key, '-inf', min)
 local cnt = redis.call('ZCOUNT', key, '-inf', '+inf')
 -- local cnt = redis.call('ZCOUNT', key, min, '+inf')
-if cnt >= threshold then
+local limited = 0
+if cnt + cost > threshold then
     -- 执行限流
-    return "true"
+    limited = 1
 else
     -- score 设置为当前时间, member 设置为唯一id
-    redis.call('ZADD', key, now, uid)
+    -- 一次消耗多个配额的时候，每个配额对应一个 member
+    for i = 1, cost do
+        redis.call('ZADD', key, now, uid .. ':' .. i)
+    end
     redis.call('PEXPIRE', key, window)
-    return "false"
-end
\ No newline at end of file
+    cnt = cnt + cost
+end
+-- 最新的请求滑出窗口之后配额完全恢复
+local reset = 0
+local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+if newest[2] ~= nil then
+    reset = tonumber(newest[2]) + window - now
+end
+-- 最早的请求滑出窗口之后就会空出一个位置
+local retry = 0
+if limited == 1 then
+    local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+    if oldest[2] ~= nil then
+        retry = tonumber(oldest[2]) + window - now
+    end
+end
+-- 返回: 是否限流, 剩余请求数, 多少毫秒之后恢复, 多少毫秒之后可以重试
+return { limited, math.max(threshold - cnt, 0), reset, retry }
@@ -0,0 +1,56 @@
+-- 限流对象
+local key = KEYS[1]
+-- 窗口大小
+local window = tonumber(ARGV[1])
+-- 阈值
+local threshold = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+-- 这次请求要消耗多少个配额
+local cost = tonumber(ARGV[4])
+-- 当前固定窗口的起始时间
+local start = now - now % window
+
+local bucket = redis.call('HMGET', key, 'start', 'cur', 'prev')
+local lastStart = tonumber(bucket[1])
+local cur = 0
+local prev = 0
+if lastStart == start then
+    cur = tonumber(bucket[2])
+    prev = tonumber(bucket[3])
+elseif lastStart == start - window then
+    -- 进入了下一个窗口，当前窗口变成了上一个窗口
+    prev = tonumber(bucket[2])
+end
+
+-- 按照上一个窗口还在滑动窗口内的比例估算请求数
+local elapsed = now - start
+local estimated = prev * (window - elapsed) / window + cur
+local limited = 0
+if estimated + cost > threshold then
+    -- 执行限流
+    limited = 1
+else
+    cur = cur + cost
+    estimated = estimated + cost
+    redis.call('HSET', key, 'start', start, 'cur', cur, 'prev', prev)
+    -- 过了两个窗口之后两个计数都没有用了
+    redis.call('PEXPIRE', key, window * 2)
+end
+
+-- 当前窗口的请求全部滑出窗口之后配额完全恢复
+local reset = start + window - now
+if cur > 0 then
+    reset = reset + window
+end
+local retry = 0
+if limited == 1 then
+    if prev > 0 and cur + cost <= threshold then
+        -- 等上一个窗口的请求滑出去一部分
+        retry = math.ceil(window * (1 - (threshold - cur - cost) / prev)) - elapsed
+    else
+        -- 当前窗口已经满了，只能等下一个窗口
+        retry = start + window - now
+    end
+end
+-- 返回: 是否限流, 剩余请求数, 多少毫秒之后恢复, 多少毫秒之后可以重试
+return { limited, math.max(math.floor(threshold - estimated), 0), reset, math.max(retry, 1) * limited }
@@ -0,0 +1,46 @@
+-- 限流对象
+local key = KEYS[1]
+-- 桶的容量，也就是允许的最大突发请求数
+local capacity = tonumber(ARGV[1])
+-- 每 interval 毫秒放入 rate 个令牌
+local rate = tonumber(ARGV[2])
+local interval = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+-- 这次请求要消耗多少个令牌
+local cost = tonumber(ARGV[5])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+    -- 第一次访问，桶是满的
+    tokens = capacity
+    ts = now
+end
+-- 多个实例之间的时钟可能有偏差，时间不能倒退
+if now > ts then
+    tokens = math.min(capacity, tokens + (now - ts) * rate / interval)
+    ts = now
+end
+
+local limited = tokens < cost
+if not limited then
+    tokens = tokens - cost
+end
+redis.call('HSET', key, 'tokens', tokens, 'ts', ts)
+-- 桶从空到满需要的时间，过了这个时间 key 可以直接删掉，效果等同于满桶
+redis.call('PEXPIRE', key, math.ceil(capacity * interval / rate))
+-- 桶重新装满需要的时间
+local reset = math.ceil((capacity - tokens) * interval / rate)
+-- 攒够 cost 个令牌需要的时间
+local retry = 0
+if limited then
+    retry = math.ceil((cost - tokens) * interval / rate)
+end
+local limitedFlag = 0
+if limited then
+    -- 执行限流
+    limitedFlag = 1
+end
+-- 返回: 是否限流, 剩余令牌数, 多少毫秒之后桶满, 多少毫秒之后可以重试
+return { limitedFlag, math.floor(tokens), reset, retry }
@@ -14,7 +14,10 @@
 
 package ratelimit
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 //go:generate mockgen -source=types.go -package=limitmocks -destination=./mocks/ratelimit.mock.go
 type Limiter interface {
@@ -23,3 +26,51 @@ type Limiter interface {
 	// err 限流器本身有没有错误
 	Limit(ctx context.Context, key string) (bool, error)
 }
+
+// DecisionLimiter 除了是否限流之外，还会返回限流的详细信息，
+// 这样可以告诉客户端还剩多少配额，以及什么时候可以重试
+type DecisionLimiter interface {
+	Limiter
+	// Decide 和 Limit 一样会消耗配额，只是返回更多的信息
+	Decide(ctx context.Context, key string) (Decision, error)
+}
+
+// CostLimiter 支持一次消耗多个配额，用于开销不同的请求，例如批量导出比普通查询贵得多
+type CostLimiter interface {
+	DecisionLimiter
+	// DecideN 一次消耗 cost 个配额。
+	// 要么全部扣除，要么被限流，一个都不扣
+	DecideN(ctx context.Context, key string, cost int64) (Decision, error)
+}
+
+// Reconfigurable 可以在运行期间修改阈值的限流器
+type Reconfigurable interface {
+	// SetLimit 修改为 interval 内允许 rate 个请求，并发安全，已经记录的请求不会丢失
+	SetLimit(interval time.Duration, rate int)
+}
+
+// Decision 是一次限流判断的结果
+type Decision struct {
+	// Limited 是否限流，true 就是要限流
+	Limited bool
+	// Limit 一个周期内允许的请求数
+	Limit int64
+	// Remaining 当前还剩多少个请求可以用
+	Remaining int64
+	// Reset 多久之后配额完全恢复
+	Reset time.Duration
+	// RetryAfter 被限流的时候，多久之后可以重试。没有被限流的时候是 0
+	RetryAfter time.Duration
+}
+
+// FailPolicy 限流器本身出错，例如 Redis 不可用的时候怎么处理请求
+type FailPolicy int
+
+const (
+	// FailClosed 拒绝请求，这是默认的策略
+	FailClosed FailPolicy = iota
+	// FailOpen 放行请求，相当于暂时不限流
+	FailOpen
+	// FailFallback 降级为本地限流
+	FailFallback
+)
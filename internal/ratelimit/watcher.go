@@ -0,0 +1,217 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ConfigSource 限流配置的来源，返回 名字 => 配置
+type ConfigSource interface {
+	Load(ctx context.Context) (map[string]string, error)
+}
+
+// RedisHashSource 从 Redis 的一个 hash 里面读取配置，field 是名字
+type RedisHashSource struct {
+	cmd redis.Cmdable
+	key string
+}
+
+func NewRedisHashSource(cmd redis.Cmdable, key string) *RedisHashSource {
+	return &RedisHashSource{cmd: cmd, key: key}
+}
+
+func (s *RedisHashSource) Load(ctx context.Context) (map[string]string, error) {
+	return s.cmd.HGetAll(ctx, s.key).Result()
+}
+
+// FileSource 从本地的 JSON 文件读取配置，例如 {"login": "10/1m", "api": 100}
+type FileSource struct {
+	path string
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+func (s *FileSource) Load(ctx context.Context) (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var vals map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&vals); err != nil {
+		return nil, fmt.Errorf("解析限流配置文件 %s 失败: %w", s.path, err)
+	}
+	res := make(map[string]string, len(vals))
+	for k, v := range vals {
+		res[k] = fmt.Sprint(v)
+	}
+	return res, nil
+}
+
+// Watcher 定时从 ConfigSource 加载配置，只有配置发生变化的时候才会修改限流器。
+// 配置里面删除了的名字会保持最后一次的值，不会恢复成初始值
+type Watcher struct {
+	source   ConfigSource
+	interval time.Duration
+	logFn    func(msg any, args ...any)
+
+	mu      sync.Mutex
+	targets map[string]func(val string) error
+	// 最后一次成功应用的配置
+	applied map[string]string
+}
+
+// NewWatcher 每 interval 加载一次配置
+func NewWatcher(source ConfigSource, interval time.Duration) *Watcher {
+	return &Watcher{
+		source:   source,
+		interval: interval,
+		logFn: func(msg any, args ...any) {
+			v := make([]any, 0, len(args)+1)
+			v = append(v, msg)
+			v = append(v, args...)
+			log.Println(v...)
+		},
+		targets: make(map[string]func(val string) error),
+		applied: make(map[string]string),
+	}
+}
+
+func (w *Watcher) SetLogFunc(fn func(msg any, args ...any)) *Watcher {
+	w.logFn = fn
+	return w
+}
+
+// Watch 配置中 name 对应的值发生变化的时候调用 apply
+func (w *Watcher) Watch(name string, apply func(val string) error) *Watcher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.targets[name] = apply
+	return w
+}
+
+// WatchRate 配置的格式是 rate/interval，例如 3000/1s 表示 1 秒内允许 3000 个请求
+func (w *Watcher) WatchRate(name string, limiter Reconfigurable) *Watcher {
+	return w.Watch(name, func(val string) error {
+		interval, rate, err := ParseRate(val)
+		if err != nil {
+			return err
+		}
+		limiter.SetLimit(interval, rate)
+		return nil
+	})
+}
+
+// WatchMaxActive 配置的格式是一个正整数，例如 100
+func (w *Watcher) WatchMaxActive(name string, setMaxActive func(maxActive int64)) *Watcher {
+	return w.Watch(name, func(val string) error {
+		maxActive, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if err != nil || maxActive <= 0 {
+			return fmt.Errorf("最大活跃个数 %q 不合法", val)
+		}
+		setMaxActive(maxActive)
+		return nil
+	})
+}
+
+// Reload 立刻加载一次配置。
+// 某一项配置不合法不会影响其它的配置，所有的错误合并之后返回
+func (w *Watcher) Reload(ctx context.Context) error {
+	vals, err := w.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	return w.apply(vals)
+}
+
+func (w *Watcher) apply(vals map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for name, apply := range w.targets {
+		val, ok := vals[name]
+		if !ok || w.applied[name] == val {
+			continue
+		}
+		if err := apply(val); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		w.applied[name] = val
+		w.logFn("限流配置已更新", name, val)
+	}
+	return errors.Join(errs...)
+}
+
+// Start 先同步加载一次配置，之后在后台定时加载，直到 ctx 结束。
+// 只有读取配置失败才会返回 error；某几项配置不合法的时候，其它的配置照常生效，
+// 不合法的配置通过 logFn 记录下来，后台也照常定时加载，修正之后就会生效
+func (w *Watcher) Start(ctx context.Context) error {
+	vals, err := w.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if err = w.apply(vals); err != nil {
+		w.logFn("加载限流配置失败", err)
+	}
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Reload(ctx); err != nil {
+					w.logFn("加载限流配置失败", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// ParseRate 解析 rate/interval 格式的配置，例如 3000/1s
+func ParseRate(val string) (time.Duration, int, error) {
+	rateStr, intervalStr, ok := strings.Cut(strings.TrimSpace(val), "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("限流配置 %q 不合法，格式是 rate/interval", val)
+	}
+	rate, err := strconv.Atoi(rateStr)
+	if err != nil || rate <= 0 {
+		return 0, 0, fmt.Errorf("限流配置 %q 的阈值不合法", val)
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("限流配置 %q 的窗口大小不合法", val)
+	}
+	return interval, rate, nil
+}
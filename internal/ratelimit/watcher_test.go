@@ -0,0 +1,158 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ecodeclub/ginx/internal/mocks"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		val          string
+		wantInterval time.Duration
+		wantRate     int
+		wantErr      bool
+	}{
+		{val: "3000/1s", wantInterval: time.Second, wantRate: 3000},
+		{val: " 10/1m ", wantInterval: time.Minute, wantRate: 10},
+		{val: "3000", wantErr: true},
+		{val: "abc/1s", wantErr: true},
+		{val: "0/1s", wantErr: true},
+		{val: "10/abc", wantErr: true},
+		{val: "10/-1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			interval, rate, err := ParseRate(tt.val)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantInterval, interval)
+			assert.Equal(t, tt.wantRate, rate)
+		})
+	}
+}
+
+func TestWatcher_FileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limit.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	limiter := &RedisSlidingWindowLimiter{Interval: time.Second, Rate: 100}
+	var (
+		maxActive int64 = 10
+		applied   int
+	)
+	w := NewWatcher(NewFileSource(path), time.Minute).
+		SetLogFunc(func(msg any, args ...any) {}).
+		WatchRate("api", limiter).
+		WatchMaxActive("active", func(n int64) {
+			applied++
+			maxActive = n
+		})
+	ctx := context.Background()
+
+	write(`{"api": "50/1m", "active": 20}`)
+	require.NoError(t, w.Reload(ctx))
+	interval, rate := limiter.limit()
+	assert.Equal(t, time.Minute, interval)
+	assert.Equal(t, 50, rate)
+	assert.Equal(t, int64(20), maxActive)
+
+	// 没有变化的配置不会重复应用，不合法的配置不影响其它的配置
+	write(`{"api": "abc", "active": 20}`)
+	assert.Error(t, w.Reload(ctx))
+	interval, rate = limiter.limit()
+	assert.Equal(t, time.Minute, interval)
+	assert.Equal(t, 50, rate)
+	assert.Equal(t, 1, applied)
+
+	// 删除了的配置保持最后一次的值
+	write(`{"api": "10/1s"}`)
+	require.NoError(t, w.Reload(ctx))
+	interval, rate = limiter.limit()
+	assert.Equal(t, time.Second, interval)
+	assert.Equal(t, 10, rate)
+	assert.Equal(t, int64(20), maxActive)
+
+	write(`{`)
+	assert.Error(t, w.Reload(ctx))
+}
+
+func TestWatcher_RedisHashSource(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	res := redis.NewMapStringStringCmd(context.Background())
+	res.SetVal(map[string]string{"active": "5"})
+	cmd.EXPECT().HGetAll(gomock.Any(), "limit:config").Return(res)
+
+	var maxActive int64
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := NewWatcher(NewRedisHashSource(cmd, "limit:config"), time.Minute).
+		SetLogFunc(func(msg any, args ...any) {}).
+		WatchMaxActive("active", func(n int64) {
+			maxActive = n
+		}).Start(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), maxActive)
+}
+
+func TestWatcher_Start(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limit.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	limiter := &RedisSlidingWindowLimiter{Interval: time.Second, Rate: 100}
+	var maxActive int64
+	var failed atomic.Int32
+	w := NewWatcher(NewFileSource(path), 10*time.Millisecond).
+		SetLogFunc(func(msg any, args ...any) {
+			if msg == "加载限流配置失败" {
+				failed.Add(1)
+			}
+		}).
+		WatchRate("api", limiter).
+		WatchMaxActive("active", func(n int64) {
+			maxActive = n
+		})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 读取配置失败直接返回
+	assert.Error(t, w.Start(ctx))
+
+	// 不合法的配置记录下来，合法的配置照常生效，修正之后在后台生效
+	write(`{"api": "abc", "active": 20}`)
+	require.NoError(t, w.Start(ctx))
+	assert.Equal(t, int64(20), maxActive)
+	assert.Positive(t, failed.Load())
+	write(`{"api": "50/1m", "active": 20}`)
+	assert.Eventually(t, func() bool {
+		_, rate := limiter.limit()
+		return rate == 50
+	}, time.Second, 10*time.Millisecond)
+}
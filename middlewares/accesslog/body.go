@@ -0,0 +1,184 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/atomic"
+)
+
+// Encoding 不是文本的请求体和响应体的记录方式
+type Encoding int32
+
+const (
+	// EncodingBase64 记录为 base64:xxx
+	EncodingBase64 Encoding = iota
+	// EncodingHex 记录为 hex:xxx
+	EncodingHex
+	// EncodingNone 不记录
+	EncodingNone
+)
+
+var defaultContentTypes = []string{
+	"application/json",
+	"application/*+json",
+	"application/x-www-form-urlencoded",
+	"text/*",
+}
+
+// formatBody 没有 Content-Type 的时候根据内容判断
+func (b *Builder) formatBody(body []byte, contentType string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	if b.isText(contentType) {
+		// 截断的时候可能把一个字符切成了两半
+		if text := trimRune(body); utf8.Valid(text) {
+			return string(text)
+		}
+	}
+	switch Encoding(b.binaryEncoding.Load()) {
+	case EncodingBase64:
+		return "base64:" + base64.StdEncoding.EncodeToString(body)
+	case EncodingHex:
+		return "hex:" + hex.EncodeToString(body)
+	default:
+		return ""
+	}
+}
+
+func (b *Builder) isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range *b.contentTypes.Load() {
+		if ok, _ := path.Match(strings.ToLower(t), mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func trimRune(body []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(body); i++ {
+		if utf8.RuneStart(body[len(body)-i]) {
+			if !utf8.FullRune(body[len(body)-i:]) {
+				return body[:len(body)-i]
+			}
+			break
+		}
+	}
+	return body
+}
+
+// bodyReader 在业务处理之前先读出前 maxLength 个字节保存下来，再拼回去交给业务，
+// 这样请求在读取请求体之前就被拒绝了也能记录下来。同时统计业务读取了多少字节
+type bodyReader struct {
+	io.ReadCloser
+	reader io.Reader
+	body   bytes.Buffer
+	n      int64
+}
+
+func newBodyReader(rc io.ReadCloser, maxLength int64) *bodyReader {
+	r := &bodyReader{ReadCloser: rc, reader: rc}
+	if maxLength <= 0 {
+		return r
+	}
+	_, err := io.Copy(&r.body, io.LimitReader(rc, maxLength))
+	rest := io.Reader(rc)
+	if err != nil {
+		// 读取出错之后，业务读完提前读出来的部分就会拿到这个错误
+		rest = errReader{err: err}
+	}
+	r.reader = io.MultiReader(bytes.NewReader(r.body.Bytes()), rest)
+	return r
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// size 从客户端读取了多少字节，提前读出来的部分业务可能没有读
+func (r *bodyReader) size() int64 {
+	return max(r.n, int64(r.body.Len()))
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// responseWriter 原样把数据写给客户端，只是额外保存前 maxLength 个字节。
+// 流式的响应，例如 SSE，不会保存
+type responseWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	maxLength int64
+	// gctx.EventStreamResp 会在别的 goroutine 里面写响应，所以要用原子操作
+	streaming atomic.Bool
+}
+
+func (r *responseWriter) Write(data []byte) (int, error) {
+	r.capture(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseWriter) WriteString(s string) (int, error) {
+	r.capture([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *responseWriter) Flush() {
+	r.streaming.Store(true)
+	r.ResponseWriter.Flush()
+}
+
+func (r *responseWriter) capture(data []byte) {
+	if r.streaming.Load() {
+		return
+	}
+	if strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream") {
+		r.streaming.Store(true)
+		return
+	}
+	remain := r.maxLength - int64(r.body.Len())
+	if remain <= 0 {
+		return
+	}
+	if int64(len(data)) > remain {
+		data = data[:remain]
+	}
+	r.body.Write(data)
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ecodeclub/ginx/gctx"
+)
+
+func TestBuilder_formatBody(t *testing.T) {
+	testCases := []struct {
+		name        string
+		builder     func(b *Builder) *Builder
+		body        []byte
+		contentType string
+		want        string
+	}{
+		{
+			name:        "JSON",
+			builder:     func(b *Builder) *Builder { return b },
+			body:        []byte(`{"a":1}`),
+			contentType: "application/json; charset=utf-8",
+			want:        `{"a":1}`,
+		},
+		{
+			name:        "没有 Content-Type",
+			builder:     func(b *Builder) *Builder { return b },
+			body:        []byte("hello"),
+			contentType: "",
+			want:        "hello",
+		},
+		{
+			name:        "二进制 base64",
+			builder:     func(b *Builder) *Builder { return b },
+			body:        []byte{0x89, 'P', 'N', 'G'},
+			contentType: "image/png",
+			want:        "base64:iVBORw==",
+		},
+		{
+			name:        "二进制 hex",
+			builder:     func(b *Builder) *Builder { return b.BinaryEncoding(EncodingHex) },
+			body:        []byte{0x89, 'P', 'N', 'G'},
+			contentType: "image/png",
+			want:        "hex:89504e47",
+		},
+		{
+			name:        "二进制不记录",
+			builder:     func(b *Builder) *Builder { return b.BinaryEncoding(EncodingNone) },
+			body:        []byte{0x89, 'P', 'N', 'G'},
+			contentType: "image/png",
+			want:        "",
+		},
+		{
+			name:        "截断的字符",
+			builder:     func(b *Builder) *Builder { return b },
+			body:        []byte("中文")[:4],
+			contentType: "text/plain",
+			want:        "中",
+		},
+		{
+			name:        "文本里面有非法字符",
+			builder:     func(b *Builder) *Builder { return b },
+			body:        []byte{'a', 0xff, 'b'},
+			contentType: "text/plain",
+			want:        "base64:Yf9i",
+		},
+		{
+			name:        "自定义 Content-Type",
+			builder:     func(b *Builder) *Builder { return b.AllowContentTypes("application/xml") },
+			body:        []byte(`{"a":1}`),
+			contentType: "application/json",
+			want:        "base64:eyJhIjoxfQ==",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := tc.builder(NewBuilder(nil))
+			assert.Equal(t, tc.want, b.formatBody(tc.body, tc.contentType))
+		})
+	}
+}
+
+func TestBuilder_ReqBodyStream(t *testing.T) {
+	var (
+		al       AccessLog
+		received int
+	)
+	server := gin.New()
+	server.Use(NewBuilder(func(ctx context.Context, log *AccessLog) {
+		al = *log
+	}).AllowReqBody().MaxLength(4).Builder())
+	server.POST("/upload", func(ctx *gin.Context) {
+		n, _ := io.Copy(io.Discard, ctx.Request.Body)
+		received = int(n)
+	})
+	server.POST("/ignore", func(ctx *gin.Context) {})
+	server.POST("/partial", func(ctx *gin.Context) {
+		data, _ := io.ReadAll(ctx.Request.Body)
+		received = len(data)
+	})
+
+	body := bytes.Repeat([]byte("a"), 1<<20)
+	req, err := http.NewRequest(http.MethodPost, "/upload", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 1<<20, received)
+	assert.Equal(t, "aaaa", al.ReqBody)
+	assert.Equal(t, int64(1<<20), al.ReqSize)
+
+	// 业务没有读取请求体，例如在读取之前就被拒绝了，也会记录下来
+	req, err = http.NewRequest(http.MethodPost, "/ignore", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.ContentLength = -1
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "hell", al.ReqBody)
+	// 没有 Content-Length 的时候是从客户端读取了的字节数
+	assert.Equal(t, int64(4), al.ReqSize)
+
+	// 提前读取的部分会拼回去，业务读到的是完整的请求体
+	req, err = http.NewRequest(http.MethodPost, "/partial", strings.NewReader("hello"))
+	require.NoError(t, err)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 5, received)
+	assert.Equal(t, "hell", al.ReqBody)
+}
+
+func TestBuilder_RespBodyStream(t *testing.T) {
+	var al AccessLog
+	server := gin.New()
+	server.Use(NewBuilder(func(ctx context.Context, log *AccessLog) {
+		al = *log
+	}).AllowRespBody().Builder())
+	server.GET("/sse", func(ctx *gin.Context) {
+		ch := (&gctx.Context{Context: ctx}).EventStreamResp()
+		ch <- []byte("data: 1\n\n")
+		ch <- []byte("data: 2\n\n")
+		// 发送空的数据会让写响应的 goroutine 退出，这样前面的数据一定已经写完了
+		ch <- []byte{}
+	})
+	server.GET("/stream", func(ctx *gin.Context) {
+		// 调用过 Flush 的都认为是流式响应
+		ctx.Writer.Flush()
+		_, _ = ctx.Writer.Write([]byte("chunk"))
+		ctx.Writer.Flush()
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/sse", nil)
+	require.NoError(t, err)
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, "", al.RespBody)
+	assert.Equal(t, 18, al.Size)
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/stream", nil)
+	require.NoError(t, err)
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, "chunk", resp.Body.String())
+	assert.Equal(t, "", al.RespBody)
+}
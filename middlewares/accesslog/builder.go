@@ -15,13 +15,17 @@
 package accesslog
 
 import (
-	"bytes"
 	"context"
-	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/atomic"
+
+	"github.com/ecodeclub/ginx/session"
 )
 
 type AccessLog struct {
@@ -34,9 +38,33 @@ type AccessLog struct {
 	//响应体
 	RespBody string
 	//处理时间
-	Duration string
+	Duration time.Duration
 	//状态码
 	Status int
+	//响应体的字节数，不受 MaxLength 的影响
+	Size int
+	//请求体的字节数，有 Content-Length 的时候就是 Content-Length，否则是实际读取的字节数
+	ReqSize int64
+	//开始处理请求的时间
+	Start time.Time
+	//协议，例如 HTTP/1.1
+	Proto string
+
+	// 下面的字段默认不记录，需要调用对应的 AllowXXX 方法
+
+	//客户端 IP
+	ClientIP  string
+	UserAgent string
+	//命中的路由，例如 /users/:id，没有命中路由的时候是空字符串
+	Route string
+	//登录用户的 id，没有登录的时候是 0
+	Uid       int64
+	RequestID string
+	//选中的请求头和响应头，多个值使用 ", " 连接
+	ReqHeaders  map[string]string
+	RespHeaders map[string]string
+	//gin.Context 中记录的错误
+	Errors []string
 }
 
 type Builder struct {
@@ -46,6 +74,24 @@ type Builder struct {
 	//
 	loggerFunc func(ctx context.Context, al *AccessLog)
 	maxLength  *atomic.Int64
+
+	allowClientIP  *atomic.Bool
+	allowUserAgent *atomic.Bool
+	allowRoute     *atomic.Bool
+	allowUid       *atomic.Bool
+	allowErrors    *atomic.Bool
+	// 为空字符串的时候不记录
+	requestIDHeader *atomic.String
+	reqHeaders      *atomic.Pointer[[]string]
+	respHeaders     *atomic.Pointer[[]string]
+	redactor        *atomic.Pointer[Redactor]
+	// 按照文本记录的 Content-Type，其余的按照 binaryEncoding 编码
+	contentTypes   *atomic.Pointer[[]string]
+	binaryEncoding *atomic.Int32
+
+	policyMu sync.Mutex
+	policies *atomic.Pointer[policySet]
+	randFunc func() float64
 }
 
 func NewBuilder(fn func(ctx context.Context, al *AccessLog)) *Builder {
@@ -54,10 +100,29 @@ func NewBuilder(fn func(ctx context.Context, al *AccessLog)) *Builder {
 		allowRespBody: atomic.NewBool(false),
 		loggerFunc:    fn,
 		maxLength:     atomic.NewInt64(1024),
+
+		allowClientIP:   atomic.NewBool(false),
+		allowUserAgent:  atomic.NewBool(false),
+		allowRoute:      atomic.NewBool(false),
+		allowUid:        atomic.NewBool(false),
+		allowErrors:     atomic.NewBool(false),
+		requestIDHeader: atomic.NewString(""),
+		reqHeaders:      atomic.NewPointer[[]string](nil),
+		respHeaders:     atomic.NewPointer[[]string](nil),
+		redactor:        atomic.NewPointer[Redactor](nil),
+		contentTypes:    atomic.NewPointer(&defaultContentTypes),
+		binaryEncoding:  atomic.NewInt32(int32(EncodingBase64)),
+
+		policies: atomic.NewPointer(&policySet{
+			def:    Policy{SampleRate: 1},
+			routes: map[string]Policy{},
+		}),
+		randFunc: rand.Float64,
 	}
 }
 
-// AllowReqBody 是否打印请求体
+// AllowReqBody 是否打印请求体。
+// 在业务处理之前读取前 MaxLength 个字节，业务没有读取请求体也能记录下来，业务读到的请求体不受影响
 func (b *Builder) AllowReqBody() *Builder {
 	b.allowReqBody.Store(true)
 	return b
@@ -75,15 +140,101 @@ func (b *Builder) MaxLength(maxLength int64) *Builder {
 	return b
 }
 
+// AllowClientIP 是否记录客户端 IP，使用的是 ctx.ClientIP()
+func (b *Builder) AllowClientIP() *Builder {
+	b.allowClientIP.Store(true)
+	return b
+}
+
+// AllowUserAgent 是否记录 User-Agent
+func (b *Builder) AllowUserAgent() *Builder {
+	b.allowUserAgent.Store(true)
+	return b
+}
+
+// AllowRoute 是否记录命中的路由
+func (b *Builder) AllowRoute() *Builder {
+	b.allowRoute.Store(true)
+	return b
+}
+
+// AllowUid 是否记录登录用户的 id。
+// 需要放在登录校验的 middleware 之后，从 session.CtxSessionKey 中读取 session
+func (b *Builder) AllowUid() *Builder {
+	b.allowUid.Store(true)
+	return b
+}
+
+// AllowErrors 是否记录 ctx.Errors
+func (b *Builder) AllowErrors() *Builder {
+	b.allowErrors.Store(true)
+	return b
+}
+
+// AllowRequestID 从 header 中读取请求 id，header 为空的时候使用 X-Request-Id。
+// 请求里面没有的时候，会尝试读取响应里面的同名 header
+func (b *Builder) AllowRequestID(header string) *Builder {
+	if header == "" {
+		header = "X-Request-Id"
+	}
+	b.requestIDHeader.Store(header)
+	return b
+}
+
+// AllowReqHeaders 记录哪些请求头
+func (b *Builder) AllowReqHeaders(names ...string) *Builder {
+	b.reqHeaders.Store(&names)
+	return b
+}
+
+// AllowRespHeaders 记录哪些响应头
+func (b *Builder) AllowRespHeaders(names ...string) *Builder {
+	b.respHeaders.Store(&names)
+	return b
+}
+
+// AllowContentTypes 设置按照文本记录的 Content-Type，会覆盖默认值。
+// 支持通配符，例如 text/*，默认是 JSON、表单和文本
+func (b *Builder) AllowContentTypes(types ...string) *Builder {
+	b.contentTypes.Store(&types)
+	return b
+}
+
+// BinaryEncoding 设置其余的 Content-Type 怎么记录，默认是 base64
+func (b *Builder) BinaryEncoding(enc Encoding) *Builder {
+	b.binaryEncoding.Store(int32(enc))
+	return b
+}
+
+// Redact 打印之前使用 r 脱敏，nil 表示不脱敏
+func (b *Builder) Redact(r *Redactor) *Builder {
+	b.redactor.Store(r)
+	return b
+}
+
 func (b *Builder) Builder() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		policies := b.policies.Load()
+		if policies.skip(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+		policy := policies.policy(ctx)
+		// 没有被采样的请求，如果要根据处理结果决定要不要记录，就先临时记录下来，
+		// 否则直接跳过，也不需要读取请求体和响应体
+		sampled := b.sampled(policy)
+		if !sampled && !policy.deferred() {
+			ctx.Next()
+			return
+		}
+
 		var (
 			//请求处理开始时间
 			start = time.Now()
 			//url
 			url = ctx.Request.URL.String()
-			//url 长度
-			curLen = int64(len(url))
+			//脱敏规则
+			redactor = b.redactor.Load()
 			//运行打印的最大长度
 			maxLength = b.maxLength.Load()
 			//是否打印请求体
@@ -92,34 +243,72 @@ func (b *Builder) Builder() gin.HandlerFunc {
 			allowRespBody = b.allowRespBody.Load()
 		)
 
-		if curLen >= maxLength {
+		if redactor != nil {
+			url = redactor.redactURL(url)
+		}
+		if int64(len(url)) >= maxLength {
 			url = url[:maxLength]
 		}
 
 		accessLog := &AccessLog{
-			Method: ctx.Request.Method,
-			Url:    url,
+			Method:  ctx.Request.Method,
+			Url:     url,
+			ReqSize: ctx.Request.ContentLength,
+			Start:   start,
+			Proto:   ctx.Request.Proto,
+		}
+		if b.allowClientIP.Load() {
+			accessLog.ClientIP = ctx.ClientIP()
+		}
+		if b.allowUserAgent.Load() {
+			accessLog.UserAgent = ctx.Request.UserAgent()
+		}
+		if names := b.reqHeaders.Load(); names != nil {
+			accessLog.ReqHeaders = pickHeaders(ctx.Request.Header, *names)
 		}
-		if ctx.Request.Body != nil && allowReqBody {
-			body, _ := ctx.GetRawData()
-			ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
-			if int64(len(body)) >= maxLength {
-				body = body[:maxLength]
+		// 只提前读取前 maxLength 个字节，不会把整个请求体读到内存里面
+		var br *bodyReader
+		if ctx.Request.Body != nil && ctx.Request.Body != http.NoBody && (allowReqBody || accessLog.ReqSize < 0) {
+			if allowReqBody {
+				br = newBodyReader(ctx.Request.Body, maxLength)
+			} else {
+				br = newBodyReader(ctx.Request.Body, 0)
 			}
-			//注意资源的消耗
-			accessLog.ReqBody = string(body)
+			ctx.Request.Body = br
 		}
 
+		var rw *responseWriter
 		if allowRespBody {
-			ctx.Writer = responseWriter{
+			rw = &responseWriter{
 				ResponseWriter: ctx.Writer,
-				al:             accessLog,
 				maxLength:      maxLength,
 			}
+			ctx.Writer = rw
 		}
 
 		defer func() {
-			accessLog.Duration = time.Since(start).String()
+			accessLog.Duration = time.Since(start)
+			// 没有显式调用 WriteHeader 的时候，gin 也会记录最终的状态码
+			accessLog.Status = ctx.Writer.Status()
+			accessLog.Size = max(ctx.Writer.Size(), 0)
+			if !sampled && !policy.hit(accessLog) {
+				return
+			}
+			if br != nil {
+				if accessLog.ReqSize < 0 {
+					accessLog.ReqSize = br.size()
+				}
+				if allowReqBody {
+					accessLog.ReqBody = b.formatBody(br.body.Bytes(), ctx.ContentType())
+				}
+			}
+			if rw != nil && !rw.streaming.Load() {
+				accessLog.RespBody = b.formatBody(rw.body.Bytes(), ctx.Writer.Header().Get("Content-Type"))
+			}
+			b.fillAfter(ctx, accessLog)
+			if redactor != nil {
+				redactor.redact(ctx, accessLog)
+			}
 			//日志打印
 			b.loggerFunc(ctx, accessLog)
 		}()
@@ -127,23 +316,38 @@ func (b *Builder) Builder() gin.HandlerFunc {
 	}
 }
 
-type responseWriter struct {
-	gin.ResponseWriter
-	al        *AccessLog
-	maxLength int64
-}
-
-func (r responseWriter) WriteHeader(statusCode int) {
-
-	r.al.Status = statusCode
-	r.ResponseWriter.WriteHeader(statusCode)
+// fillAfter 记录那些要等到请求处理完才能确定的字段
+func (b *Builder) fillAfter(ctx *gin.Context, al *AccessLog) {
+	if b.allowRoute.Load() {
+		al.Route = ctx.FullPath()
+	}
+	if b.allowUid.Load() {
+		if val, ok := ctx.Get(session.CtxSessionKey); ok {
+			if sess, ok := val.(session.Session); ok {
+				al.Uid = sess.Claims().Uid
+			}
+		}
+	}
+	if b.allowErrors.Load() {
+		al.Errors = ctx.Errors.Errors()
+	}
+	if header := b.requestIDHeader.Load(); header != "" {
+		al.RequestID = ctx.GetHeader(header)
+		if al.RequestID == "" {
+			al.RequestID = ctx.Writer.Header().Get(header)
+		}
+	}
+	if names := b.respHeaders.Load(); names != nil {
+		al.RespHeaders = pickHeaders(ctx.Writer.Header(), *names)
+	}
 }
 
-func (r responseWriter) Write(data []byte) (int, error) {
-	curLen := int64(len(data))
-	if curLen >= r.maxLength {
-		data = data[:r.maxLength]
+func pickHeaders(header http.Header, names []string) map[string]string {
+	res := make(map[string]string, len(names))
+	for _, name := range names {
+		if vals := header.Values(name); len(vals) > 0 {
+			res[http.CanonicalHeaderKey(name)] = strings.Join(vals, ", ")
+		}
 	}
-	r.al.RespBody = string(data)
-	return r.ResponseWriter.Write(data)
+	return res
 }
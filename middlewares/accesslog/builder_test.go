@@ -16,7 +16,9 @@ package accesslog
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -26,6 +28,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/ecodeclub/ginx/session"
 )
 
 func TestBuilder_Builder(t *testing.T) {
@@ -73,6 +77,7 @@ func TestBuilder_Builder(t *testing.T) {
 			resultAccessLog: &AccessLog{
 				Method: "GET",
 				Url:    "/accesslog",
+				Status: http.StatusOK,
 			},
 		},
 		{
@@ -128,6 +133,7 @@ func TestBuilder_Builder(t *testing.T) {
 				Method:  "GET",
 				Url:     "/accesslog",
 				ReqBody: `{"msg":"aa11"}`,
+				Status:  http.StatusOK,
 			},
 		},
 		{
@@ -186,6 +192,7 @@ func TestBuilder_Builder(t *testing.T) {
 				Method:  "GET",
 				Url:     "/accesslog",
 				ReqBody: `{"msg":"aa`,
+				Status:  http.StatusOK,
 			},
 		},
 		{
@@ -241,12 +248,126 @@ func TestBuilder_Builder(t *testing.T) {
 			//assert.Equal(t, tc.accesslog.Duration, tc.resultAccessLog.Duration)
 
 			assert.Equal(t, tc.accesslog.Status, tc.resultAccessLog.Status)
-
+			assert.Equal(t, resp.Body.Len(), tc.accesslog.Size)
+			// 客户端收到的响应不受 MaxLength 的影响
+			assert.Equal(t, `{"msg":"aa22"}`, resp.Body.String())
 		})
 
 	}
 }
 
+func TestBuilder_RespBodyChunks(t *testing.T) {
+	var al AccessLog
+	server := gin.New()
+	server.Use(NewBuilder(func(ctx context.Context, log *AccessLog) {
+		al = *log
+	}).AllowRespBody().MaxLength(8).Builder())
+	server.GET("/chunks", func(ctx *gin.Context) {
+		// 没有显式调用 WriteHeader
+		_, _ = ctx.Writer.Write([]byte("abc"))
+		_, _ = ctx.Writer.WriteString("defg")
+		_, _ = ctx.Writer.Write([]byte("hijklmn"))
+	})
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/chunks", nil)
+	require.NoError(t, err)
+	server.ServeHTTP(resp, req)
+
+	assert.Equal(t, "abcdefghijklmn", resp.Body.String())
+	assert.Equal(t, "abcdefgh", al.RespBody)
+	assert.Equal(t, http.StatusOK, al.Status)
+	assert.Equal(t, 14, al.Size)
+}
+
+func TestBuilder_Fields(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder func(b *Builder) *Builder
+		want    AccessLog
+	}{
+		{
+			name:    "默认不记录",
+			builder: func(b *Builder) *Builder { return b },
+			want: AccessLog{
+				Method:  http.MethodPost,
+				Url:     "/users/123",
+				Status:  http.StatusCreated,
+				Size:    2,
+				ReqSize: 5,
+				Proto:   "HTTP/1.1",
+			},
+		},
+		{
+			name: "记录所有字段",
+			builder: func(b *Builder) *Builder {
+				return b.AllowClientIP().AllowUserAgent().AllowRoute().AllowUid().AllowErrors().
+					AllowRequestID("").AllowReqHeaders("X-Tenant", "X-Missing").AllowRespHeaders("X-Cache")
+			},
+			want: AccessLog{
+				Method:      http.MethodPost,
+				Url:         "/users/123",
+				Status:      http.StatusCreated,
+				Size:        2,
+				ReqSize:     5,
+				Proto:       "HTTP/1.1",
+				ClientIP:    "10.0.0.1",
+				UserAgent:   "test-agent",
+				Route:       "/users/:id",
+				Uid:         123,
+				RequestID:   "req-1",
+				ReqHeaders:  map[string]string{"X-Tenant": "a, b"},
+				RespHeaders: map[string]string{"X-Cache": "HIT"},
+				Errors:      []string{"出错了"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var al AccessLog
+			server := gin.New()
+			server.Use(func(ctx *gin.Context) {
+				ctx.Set(session.CtxSessionKey, session.NewMemorySession(session.Claims{Uid: 123}))
+			}, tc.builder(NewBuilder(func(ctx context.Context, log *AccessLog) {
+				al = *log
+			})).Builder())
+			server.POST("/users/:id", func(ctx *gin.Context) {
+				_ = ctx.Error(errors.New("出错了"))
+				ctx.Header("X-Cache", "HIT")
+				ctx.String(http.StatusCreated, "ok")
+			})
+			req, err := http.NewRequest(http.MethodPost, "/users/123", strings.NewReader("hello"))
+			require.NoError(t, err)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("User-Agent", "test-agent")
+			req.Header.Set("X-Request-Id", "req-1")
+			req.Header.Add("X-Tenant", "a")
+			req.Header.Add("X-Tenant", "b")
+			server.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.True(t, al.Duration > 0)
+			assert.False(t, al.Start.IsZero())
+			al.Duration, al.Start = 0, time.Time{}
+			assert.Equal(t, tc.want, al)
+		})
+	}
+}
+
+func TestBuilder_ReqSizeChunked(t *testing.T) {
+	var al AccessLog
+	server := gin.New()
+	server.Use(NewBuilder(func(ctx context.Context, log *AccessLog) {
+		al = *log
+	}).Builder())
+	server.POST("/upload", func(ctx *gin.Context) {
+		_, _ = io.Copy(io.Discard, ctx.Request.Body)
+	})
+	req, err := http.NewRequest(http.MethodPost, "/upload", io.NopCloser(strings.NewReader("hello world")))
+	require.NoError(t, err)
+	req.ContentLength = -1
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, int64(11), al.ReqSize)
+}
+
 func copy(source, target *AccessLog) {
 	source.Status = target.Status
 	source.Method = target.Method
@@ -260,4 +381,5 @@ func copy(source, target *AccessLog) {
 	source.Duration = target.Duration
 	//状态码
 	source.Status = target.Status
+	source.Size = target.Size
 }
@@ -0,0 +1,191 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formatter 把一条日志格式化成一行，不包含换行符
+type Formatter func(al *AccessLog) []byte
+
+// JSONFormatter 每条日志是一个 JSON 对象，也就是 JSON lines 格式。
+// 字段名使用下划线风格，处理时间使用 time.Duration 的字符串形式，例如 1.5ms
+func JSONFormatter() Formatter {
+	return func(al *AccessLog) []byte {
+		data, err := json.Marshal(jsonAccessLog{
+			Method:      al.Method,
+			Url:         al.Url,
+			ReqBody:     al.ReqBody,
+			RespBody:    al.RespBody,
+			Duration:    al.Duration.String(),
+			Status:      al.Status,
+			Size:        al.Size,
+			ReqSize:     al.ReqSize,
+			Start:       al.Start,
+			Proto:       al.Proto,
+			ClientIP:    al.ClientIP,
+			UserAgent:   al.UserAgent,
+			Route:       al.Route,
+			Uid:         al.Uid,
+			RequestID:   al.RequestID,
+			ReqHeaders:  al.ReqHeaders,
+			RespHeaders: al.RespHeaders,
+			Errors:      al.Errors,
+		})
+		if err != nil {
+			return []byte(`{"error":` + strconv.Quote(err.Error()) + `}`)
+		}
+		return data
+	}
+}
+
+// jsonAccessLog JSONFormatter 输出的格式。
+// AccessLog 本身不加 json tag，直接序列化 AccessLog 的用户看到的字段名保持不变
+type jsonAccessLog struct {
+	Method      string            `json:"method"`
+	Url         string            `json:"url"`
+	ReqBody     string            `json:"req_body,omitempty"`
+	RespBody    string            `json:"resp_body,omitempty"`
+	Duration    string            `json:"duration"`
+	Status      int               `json:"status"`
+	Size        int               `json:"size"`
+	ReqSize     int64             `json:"req_size"`
+	Start       time.Time         `json:"start"`
+	Proto       string            `json:"proto"`
+	ClientIP    string            `json:"client_ip,omitempty"`
+	UserAgent   string            `json:"user_agent,omitempty"`
+	Route       string            `json:"route,omitempty"`
+	Uid         int64             `json:"uid,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	ReqHeaders  map[string]string `json:"req_headers,omitempty"`
+	RespHeaders map[string]string `json:"resp_headers,omitempty"`
+	Errors      []string          `json:"errors,omitempty"`
+}
+
+// CombinedFormatter Apache/Nginx 的 combined 格式：
+// %h - %u [%t] "%r" %>s %b "%{Referer}i" "%{User-agent}i"。
+// 用户是 uid，Referer 需要通过 AllowReqHeaders("Referer") 记录下来，没有的字段输出 -
+func CombinedFormatter() Formatter {
+	return func(al *AccessLog) []byte {
+		var sb strings.Builder
+		sb.WriteString(orDash(al.ClientIP))
+		sb.WriteString(" - ")
+		if al.Uid > 0 {
+			sb.WriteString(strconv.FormatInt(al.Uid, 10))
+		} else {
+			sb.WriteString("-")
+		}
+		sb.WriteString(" [")
+		sb.WriteString(al.Start.Format("02/Jan/2006:15:04:05 -0700"))
+		sb.WriteString(`] "`)
+		sb.WriteString(al.Method + " " + al.Url + " " + al.Proto)
+		sb.WriteString(`" `)
+		sb.WriteString(strconv.Itoa(al.Status))
+		sb.WriteString(" ")
+		if al.Size > 0 {
+			sb.WriteString(strconv.Itoa(al.Size))
+		} else {
+			sb.WriteString("-")
+		}
+		sb.WriteString(` "`)
+		sb.WriteString(escape(orDash(al.ReqHeaders["Referer"])))
+		sb.WriteString(`" "`)
+		sb.WriteString(escape(orDash(al.UserAgent)))
+		sb.WriteString(`"`)
+		return []byte(sb.String())
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+// WriterLogger 使用 f 格式化之后写入 w，一条日志一行。
+// 写入是同步的，如果 w 比较慢，可以配合 AsyncSink 使用
+func WriterLogger(w io.Writer, f Formatter) func(ctx context.Context, al *AccessLog) {
+	var mu sync.Mutex
+	return func(ctx context.Context, al *AccessLog) {
+		line := append(f(al), '\n')
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(line)
+	}
+}
+
+// SlogLogger 使用 slog 输出结构化的日志。
+// 5xx 是 Error 级别，4xx 是 Warn 级别，其余是 Info 级别，没有记录的字段不会输出
+func SlogLogger(l *slog.Logger) func(ctx context.Context, al *AccessLog) {
+	return func(ctx context.Context, al *AccessLog) {
+		level := slog.LevelInfo
+		switch {
+		case al.Status >= 500:
+			level = slog.LevelError
+		case al.Status >= 400:
+			level = slog.LevelWarn
+		}
+		if !l.Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", al.Method),
+			slog.String("url", al.Url),
+			slog.Int("status", al.Status),
+			slog.Duration("duration", al.Duration),
+			slog.Int("size", al.Size),
+			slog.Int64("req_size", al.ReqSize),
+		}
+		attrs = appendString(attrs, "req_body", al.ReqBody)
+		attrs = appendString(attrs, "resp_body", al.RespBody)
+		attrs = appendString(attrs, "client_ip", al.ClientIP)
+		attrs = appendString(attrs, "user_agent", al.UserAgent)
+		attrs = appendString(attrs, "route", al.Route)
+		attrs = appendString(attrs, "request_id", al.RequestID)
+		if al.Uid > 0 {
+			attrs = append(attrs, slog.Int64("uid", al.Uid))
+		}
+		if len(al.ReqHeaders) > 0 {
+			attrs = append(attrs, slog.Any("req_headers", al.ReqHeaders))
+		}
+		if len(al.RespHeaders) > 0 {
+			attrs = append(attrs, slog.Any("resp_headers", al.RespHeaders))
+		}
+		if len(al.Errors) > 0 {
+			attrs = append(attrs, slog.Any("errors", al.Errors))
+		}
+		l.LogAttrs(ctx, level, "access", attrs...)
+	}
+}
+
+func appendString(attrs []slog.Attr, key, val string) []slog.Attr {
+	if val == "" {
+		return attrs
+	}
+	return append(attrs, slog.String(key, val))
+}
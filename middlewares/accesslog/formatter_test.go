@@ -0,0 +1,93 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAccessLog() *AccessLog {
+	return &AccessLog{
+		Method:     "GET",
+		Url:        "/users/123?a=1",
+		Duration:   1500 * time.Microsecond,
+		Status:     404,
+		Size:       9,
+		Start:      time.Date(2023, time.October, 10, 13, 55, 36, 0, time.FixedZone("CST", 8*3600)),
+		Proto:      "HTTP/1.1",
+		ClientIP:   "127.0.0.1",
+		UserAgent:  `curl/8.0 "test"`,
+		Uid:        123,
+		ReqHeaders: map[string]string{"Referer": "https://example.com"},
+	}
+}
+
+func TestJSONFormatter(t *testing.T) {
+	assert.JSONEq(t, `{"method":"GET","url":"/users/123?a=1","duration":"1.5ms","status":404,"size":9,
+"req_size":0,"start":"2023-10-10T13:55:36+08:00","proto":"HTTP/1.1","client_ip":"127.0.0.1",
+"user_agent":"curl/8.0 \"test\"","uid":123,"req_headers":{"Referer":"https://example.com"}}`,
+		string(JSONFormatter()(testAccessLog())))
+
+	// 直接序列化 AccessLog 的字段名不受影响
+	data, err := json.Marshal(&AccessLog{Method: "GET", Duration: time.Millisecond})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Method":"GET"`)
+	assert.Contains(t, string(data), `"Duration":1000000`)
+}
+
+func TestCombinedFormatter(t *testing.T) {
+	assert.Equal(t, `127.0.0.1 - 123 [10/Oct/2023:13:55:36 +0800] "GET /users/123?a=1 HTTP/1.1" 404 9 "https://example.com" "curl/8.0 \"test\""`,
+		string(CombinedFormatter()(testAccessLog())))
+	assert.Equal(t, `- - - [01/Jan/0001:00:00:00 +0000] "GET / HTTP/1.1" 200 - "-" "-"`,
+		string(CombinedFormatter()(&AccessLog{Method: "GET", Url: "/", Proto: "HTTP/1.1", Status: 200})))
+}
+
+func TestWriterLogger(t *testing.T) {
+	var buf bytes.Buffer
+	fn := WriterLogger(&buf, func(al *AccessLog) []byte {
+		return []byte(al.Method)
+	})
+	fn(context.Background(), &AccessLog{Method: "GET"})
+	fn(context.Background(), &AccessLog{Method: "POST"})
+	assert.Equal(t, "GET\nPOST\n", buf.String())
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	SlogLogger(l)(context.Background(), testAccessLog())
+	assert.Equal(t, `level=WARN msg=access method=GET url="/users/123?a=1" status=404 duration=1.5ms size=9 req_size=0 `+
+		`client_ip=127.0.0.1 user_agent="curl/8.0 \"test\"" uid=123 req_headers=map[Referer:https://example.com]`+"\n", buf.String())
+
+	buf.Reset()
+	l = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelError}))
+	SlogLogger(l)(context.Background(), testAccessLog())
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy 决定一个请求要不要记录。
+// 满足任意一个条件就会记录，例如只记录错误可以是 Policy{MinStatus: 500}
+type Policy struct {
+	// SampleRate 采样的比例，1 表示全部记录，0 表示不采样
+	SampleRate float64
+	// SlowThreshold 处理时间大于等于 SlowThreshold 的请求一定记录，0 表示不启用
+	SlowThreshold time.Duration
+	// MinStatus 状态码大于等于 MinStatus 的请求一定记录，例如 400、500，0 表示不启用
+	MinStatus int
+}
+
+// 需要等到请求处理完才能确定要不要记录
+func (p Policy) deferred() bool {
+	return p.SlowThreshold > 0 || p.MinStatus > 0
+}
+
+func (p Policy) hit(al *AccessLog) bool {
+	return (p.SlowThreshold > 0 && al.Duration >= p.SlowThreshold) ||
+		(p.MinStatus > 0 && al.Status >= p.MinStatus)
+}
+
+type policySet struct {
+	def Policy
+	// 和 ratelimit.CostByRoute 一样，key 是 "GET /users/:id" 或者 "/users/:id"
+	routes map[string]Policy
+	// 请求的路径，以 * 结尾表示前缀匹配
+	skips []string
+}
+
+func (s *policySet) clone() *policySet {
+	res := &policySet{
+		def:    s.def,
+		routes: make(map[string]Policy, len(s.routes)),
+		skips:  append([]string(nil), s.skips...),
+	}
+	for k, v := range s.routes {
+		res.routes[k] = v
+	}
+	return res
+}
+
+func (s *policySet) skip(path string) bool {
+	for _, p := range s.skips {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *policySet) policy(ctx *gin.Context) Policy {
+	path := ctx.FullPath()
+	if p, ok := s.routes[ctx.Request.Method+" "+path]; ok {
+		return p
+	}
+	if p, ok := s.routes[path]; ok {
+		return p
+	}
+	return s.def
+}
+
+// SetPolicy 默认的记录策略，默认全部记录
+func (b *Builder) SetPolicy(p Policy) *Builder {
+	b.updatePolicies(func(s *policySet) {
+		s.def = p
+	})
+	return b
+}
+
+// SetRoutePolicy 覆盖某个路由的策略。
+// route 可以是 "GET /users/:id" 这种方法加路由模式的形式，也可以只有路由模式，优先匹配带方法的
+func (b *Builder) SetRoutePolicy(route string, p Policy) *Builder {
+	b.updatePolicies(func(s *policySet) {
+		s.routes[route] = p
+	})
+	return b
+}
+
+// Skip 不记录这些路径的请求，例如健康检查和静态资源。
+// 匹配的是请求的路径，而不是路由模式，以 * 结尾表示前缀匹配，例如 /static/*
+func (b *Builder) Skip(paths ...string) *Builder {
+	b.updatePolicies(func(s *policySet) {
+		s.skips = append(s.skips, paths...)
+	})
+	return b
+}
+
+func (b *Builder) updatePolicies(fn func(s *policySet)) {
+	b.policyMu.Lock()
+	defer b.policyMu.Unlock()
+	s := b.policies.Load().clone()
+	fn(s)
+	b.policies.Store(s)
+}
+
+// sampled 是否被采样
+func (b *Builder) sampled(p Policy) bool {
+	return p.SampleRate >= 1 || (p.SampleRate > 0 && b.randFunc() < p.SampleRate)
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder_Policy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder func(b *Builder) *Builder
+		rand    float64
+		path    string
+		// 是否记录，以及有没有记录响应体
+		wantLogged bool
+		wantBody   string
+	}{
+		{
+			name:       "默认全部记录",
+			builder:    func(b *Builder) *Builder { return b },
+			path:       "/ok",
+			wantLogged: true,
+			wantBody:   "ok",
+		},
+		{
+			name:       "跳过",
+			builder:    func(b *Builder) *Builder { return b.Skip("/health", "/static/*") },
+			path:       "/static/app.js",
+			wantLogged: false,
+		},
+		{
+			name: "被采样",
+			builder: func(b *Builder) *Builder {
+				return b.SetPolicy(Policy{SampleRate: 0.1})
+			},
+			rand:       0.05,
+			path:       "/ok",
+			wantLogged: true,
+			wantBody:   "ok",
+		},
+		{
+			name: "没有被采样",
+			builder: func(b *Builder) *Builder {
+				return b.SetPolicy(Policy{SampleRate: 0.1})
+			},
+			rand:       0.5,
+			path:       "/ok",
+			wantLogged: false,
+		},
+		{
+			name: "只记录错误",
+			builder: func(b *Builder) *Builder {
+				return b.SetPolicy(Policy{MinStatus: 500})
+			},
+			path:       "/error",
+			wantLogged: true,
+			wantBody:   "error",
+		},
+		{
+			name: "只记录错误，正常的请求",
+			builder: func(b *Builder) *Builder {
+				return b.SetPolicy(Policy{MinStatus: 500})
+			},
+			path:       "/ok",
+			wantLogged: false,
+		},
+		{
+			name: "慢请求",
+			builder: func(b *Builder) *Builder {
+				return b.SetPolicy(Policy{SlowThreshold: 10 * time.Millisecond})
+			},
+			path:       "/slow",
+			wantLogged: true,
+			wantBody:   "slow",
+		},
+		{
+			name: "路由覆盖",
+			builder: func(b *Builder) *Builder {
+				return b.SetPolicy(Policy{}).SetRoutePolicy("GET /ok", Policy{SampleRate: 1})
+			},
+			path:       "/ok",
+			wantLogged: true,
+			wantBody:   "ok",
+		},
+		{
+			name: "路由覆盖，方法不匹配",
+			builder: func(b *Builder) *Builder {
+				return b.SetRoutePolicy("POST /ok", Policy{})
+			},
+			path:       "/ok",
+			wantLogged: true,
+			wantBody:   "ok",
+		},
+		{
+			name: "路由覆盖，只有路由模式",
+			builder: func(b *Builder) *Builder {
+				return b.SetRoutePolicy("/ok", Policy{})
+			},
+			path:       "/ok",
+			wantLogged: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var al *AccessLog
+			b := NewBuilder(func(ctx context.Context, log *AccessLog) {
+				al = log
+			}).AllowRespBody()
+			b.randFunc = func() float64 { return tc.rand }
+			server := gin.New()
+			server.Use(tc.builder(b).Builder())
+			server.GET("/ok", func(ctx *gin.Context) {
+				ctx.String(http.StatusOK, "ok")
+			})
+			server.GET("/error", func(ctx *gin.Context) {
+				ctx.String(http.StatusInternalServerError, "error")
+			})
+			server.GET("/slow", func(ctx *gin.Context) {
+				time.Sleep(20 * time.Millisecond)
+				ctx.String(http.StatusOK, "slow")
+			})
+			server.GET("/static/*file", func(ctx *gin.Context) {
+				ctx.String(http.StatusOK, "static")
+			})
+			req, err := http.NewRequest(http.MethodGet, tc.path, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.NotEmpty(t, resp.Body.String())
+			assert.Equal(t, tc.wantLogged, al != nil)
+			if al != nil {
+				assert.Equal(t, tc.wantBody, al.RespBody)
+			}
+		})
+	}
+}
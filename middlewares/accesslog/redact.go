@@ -0,0 +1,261 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Redactor 对日志里面的敏感数据脱敏，只会修改 AccessLog，不会影响请求和响应。
+// 规则需要在 Builder 开始处理请求之前设置好
+type Redactor struct {
+	mask string
+	// JSON 字段。包含 . 的是路径，例如 user.password、*.password；
+	// 否则是任意一层的字段名，例如 *token*。都不区分大小写
+	jsonFields []string
+	// 表单字段和查询参数，模式和字段名一样
+	formFields []string
+	// canonical 之后的 header 名字
+	headers  map[string]struct{}
+	patterns []pattern
+}
+
+type pattern struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{
+		mask:    "***",
+		headers: make(map[string]struct{}),
+	}
+}
+
+// DefaultRedactor 脱敏常见的密码、token 字段和 Authorization、Cookie 等请求头
+func DefaultRedactor() *Redactor {
+	fields := []string{"*password*", "*passwd*", "*secret*", "*token*"}
+	return NewRedactor().
+		JSONFields(fields...).
+		FormFields(fields...).
+		Headers("Authorization", "Cookie", "Set-Cookie", "X-Refresh-Token", "X-Access-Token")
+}
+
+// Mask 替换敏感数据的字符串，默认是 ***
+func (r *Redactor) Mask(mask string) *Redactor {
+	r.mask = mask
+	return r
+}
+
+// JSONFields 脱敏 JSON 请求体和响应体中的字段
+func (r *Redactor) JSONFields(fields ...string) *Redactor {
+	r.jsonFields = append(r.jsonFields, fields...)
+	return r
+}
+
+// FormFields 脱敏表单请求体和 URL 中的查询参数
+func (r *Redactor) FormFields(fields ...string) *Redactor {
+	r.formFields = append(r.formFields, fields...)
+	return r
+}
+
+// Headers 脱敏请求头和响应头
+func (r *Redactor) Headers(names ...string) *Redactor {
+	for _, name := range names {
+		r.headers[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+	return r
+}
+
+// Pattern 使用正则表达式脱敏 URL 和请求体、响应体中的文本，
+// repl 的语法和 regexp.ReplaceAllString 一样
+func (r *Redactor) Pattern(re *regexp.Regexp, repl string) *Redactor {
+	r.patterns = append(r.patterns, pattern{re: re, repl: repl})
+	return r
+}
+
+// Phone 脱敏中国大陆的手机号，保留前三位和后四位
+func (r *Redactor) Phone() *Redactor {
+	return r.Pattern(regexp.MustCompile(`\b(1[3-9]\d)\d{4}(\d{4})\b`), "${1}****${2}")
+}
+
+// Email 脱敏邮箱，只保留第一个字符和域名
+func (r *Redactor) Email() *Redactor {
+	return r.Pattern(regexp.MustCompile(`\b([A-Za-z0-9])[A-Za-z0-9._%+-]*(@[A-Za-z0-9.-]+\.[A-Za-z]{2,})\b`), "${1}***${2}")
+}
+
+// IDCard 脱敏 18 位身份证号，保留前六位和后四位
+func (r *Redactor) IDCard() *Redactor {
+	return r.Pattern(regexp.MustCompile(`\b(\d{6})\d{8}(\d{3}[\dXx])\b`), "${1}********${2}")
+}
+
+func (r *Redactor) redact(ctx *gin.Context, al *AccessLog) {
+	al.ReqBody = r.redactBody(al.ReqBody, ctx.ContentType())
+	al.RespBody = r.redactBody(al.RespBody, ctx.Writer.Header().Get("Content-Type"))
+	r.redactHeaders(al.ReqHeaders)
+	r.redactHeaders(al.RespHeaders)
+}
+
+func (r *Redactor) redactURL(u string) string {
+	before, query, ok := strings.Cut(u, "?")
+	if ok && len(r.formFields) > 0 {
+		u = before + "?" + r.redactForm(query)
+	}
+	return r.redactText(u)
+}
+
+func (r *Redactor) redactBody(body string, contentType string) string {
+	if body == "" {
+		return body
+	}
+	switch {
+	case strings.Contains(contentType, "json"):
+		body = r.redactJSON(body)
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		body = r.redactForm(body)
+	}
+	return r.redactText(body)
+}
+
+func (r *Redactor) redactHeaders(headers map[string]string) {
+	for name := range headers {
+		if _, ok := r.headers[name]; ok {
+			headers[name] = r.mask
+		}
+	}
+}
+
+func (r *Redactor) redactText(s string) string {
+	for _, p := range r.patterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// redactForm 保持参数原本的顺序，只替换值
+func (r *Redactor) redactForm(form string) string {
+	pairs := strings.Split(form, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && matchName(r.formFields, name) {
+			pairs[i] = key + "=" + url.QueryEscape(r.mask)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (r *Redactor) redactJSON(body string) string {
+	if len(r.jsonFields) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var val any
+	if err := dec.Decode(&val); err != nil {
+		// 超过 MaxLength 被截断的 JSON 没办法解析，只能按照字段名替换
+		return r.redactBrokenJSON(body)
+	}
+	val = r.walk(val, nil)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		return r.redactBrokenJSON(body)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (r *Redactor) walk(val any, keys []string) any {
+	switch v := val.(type) {
+	case map[string]any:
+		for k, child := range v {
+			p := append(keys, k)
+			if r.matchJSON(p) {
+				v[k] = r.mask
+				continue
+			}
+			v[k] = r.walk(child, p)
+		}
+	case []any:
+		// 数组不算一层
+		for i, child := range v {
+			v[i] = r.walk(child, keys)
+		}
+	}
+	return val
+}
+
+func (r *Redactor) matchJSON(keys []string) bool {
+	for _, field := range r.jsonFields {
+		if !strings.Contains(field, ".") {
+			if matchName([]string{field}, keys[len(keys)-1]) {
+				return true
+			}
+			continue
+		}
+		segs := strings.Split(field, ".")
+		if len(segs) != len(keys) {
+			continue
+		}
+		matched := true
+		for i, seg := range segs {
+			if !matchName([]string{seg}, keys[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+var brokenJSONField = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,{}\[\]\s"]+)`)
+
+func (r *Redactor) redactBrokenJSON(body string) string {
+	return brokenJSONField.ReplaceAllStringFunc(body, func(s string) string {
+		m := brokenJSONField.FindStringSubmatch(s)
+		name := m[1]
+		// 路径没办法判断，所以这里只用最后一层的名字匹配
+		for _, field := range r.jsonFields {
+			if idx := strings.LastIndex(field, "."); idx >= 0 {
+				field = field[idx+1:]
+			}
+			if matchName([]string{field}, name) {
+				return `"` + name + `"` + m[2] + `"` + r.mask + `"`
+			}
+		}
+		return s
+	})
+}
+
+func matchName(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
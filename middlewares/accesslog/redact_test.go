@@ -0,0 +1,144 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor_redactBody(t *testing.T) {
+	testCases := []struct {
+		name        string
+		redactor    *Redactor
+		body        string
+		contentType string
+		want        string
+	}{
+		{
+			name:        "JSON 字段名",
+			redactor:    NewRedactor().JSONFields("password", "*token*"),
+			body:        `{"name":"tom","Password":"123","tokens":[{"accessToken":"abc"}]}`,
+			contentType: "application/json",
+			want:        `{"Password":"***","name":"tom","tokens":"***"}`,
+		},
+		{
+			name:        "JSON 路径",
+			redactor:    NewRedactor().JSONFields("user.*.number"),
+			body:        `{"user":{"card":{"number":"6222"},"name":"tom"},"number":1}`,
+			contentType: "application/json; charset=utf-8",
+			want:        `{"number":1,"user":{"card":{"number":"***"},"name":"tom"}}`,
+		},
+		{
+			name:        "数组不算一层",
+			redactor:    NewRedactor().JSONFields("users.password"),
+			body:        `{"users":[{"password":"1"},{"password":2}]}`,
+			contentType: "application/json",
+			want:        `{"users":[{"password":"***"},{"password":"***"}]}`,
+		},
+		{
+			name:        "截断的 JSON",
+			redactor:    NewRedactor().JSONFields("user.password"),
+			body:        `{"user":{"password":"123","age":18,"na`,
+			contentType: "application/json",
+			want:        `{"user":{"password":"***","age":18,"na`,
+		},
+		{
+			name:        "表单",
+			redactor:    NewRedactor().FormFields("password"),
+			body:        "name=tom&password=123&code",
+			contentType: "application/x-www-form-urlencoded",
+			want:        "name=tom&password=%2A%2A%2A&code",
+		},
+		{
+			name:        "不是 JSON 不按照字段脱敏",
+			redactor:    NewRedactor().JSONFields("password"),
+			body:        `{"password":"123"}`,
+			contentType: "text/plain",
+			want:        `{"password":"123"}`,
+		},
+		{
+			name:        "正则",
+			redactor:    NewRedactor().Phone().Email().IDCard(),
+			body:        "13812345678 tom.cat@example.com 11010519491231002X",
+			contentType: "text/plain",
+			want:        "138****5678 t***@example.com 110105********002X",
+		},
+		{
+			name:        "自定义正则",
+			redactor:    NewRedactor().Pattern(regexp.MustCompile(`sk-\w+`), "sk-***"),
+			body:        `{"key":"sk-abc123"}`,
+			contentType: "application/json",
+			want:        `{"key":"sk-***"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.redactor.redactBody(tc.body, tc.contentType))
+		})
+	}
+}
+
+func TestRedactor_redactURL(t *testing.T) {
+	r := NewRedactor().FormFields("token", "*secret").Phone()
+	assert.Equal(t, "/login?token=%2A%2A%2A&b=1&client_secret=%2A%2A%2A&phone=138****5678",
+		r.redactURL("/login?token=abc&b=1&client_secret=xyz&phone=13812345678"))
+	assert.Equal(t, "/login", r.redactURL("/login"))
+}
+
+func TestBuilder_Redact(t *testing.T) {
+	var (
+		al      AccessLog
+		handled string
+	)
+	server := gin.New()
+	server.Use(NewBuilder(func(ctx context.Context, log *AccessLog) {
+		al = *log
+	}).AllowReqBody().AllowRespBody().AllowReqHeaders("Authorization").AllowRespHeaders("Set-Cookie").
+		Redact(DefaultRedactor()).Builder())
+	server.POST("/login", func(ctx *gin.Context) {
+		body, _ := io.ReadAll(ctx.Request.Body)
+		handled = string(body) + " " + ctx.GetHeader("Authorization") + " " + ctx.Query("access_token")
+		ctx.Header("Set-Cookie", "ssid=123")
+		ctx.JSON(http.StatusOK, gin.H{"token": "jwt"})
+	})
+	req, err := http.NewRequest(http.MethodPost, "/login?access_token=abc",
+		strings.NewReader(`{"email":"a@b.com","password":"123"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer xyz")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	// 业务和客户端看到的都是原始数据
+	assert.Equal(t, `{"email":"a@b.com","password":"123"} Bearer xyz abc`, handled)
+	assert.Equal(t, `{"token":"jwt"}`, resp.Body.String())
+	assert.Equal(t, "ssid=123", resp.Header().Get("Set-Cookie"))
+
+	assert.Equal(t, "/login?access_token=%2A%2A%2A", al.Url)
+	assert.Equal(t, `{"email":"a@b.com","password":"***"}`, al.ReqBody)
+	assert.Equal(t, `{"token":"***"}`, al.RespBody)
+	assert.Equal(t, map[string]string{"Authorization": "***"}, al.ReqHeaders)
+	assert.Equal(t, map[string]string{"Set-Cookie": "***"}, al.RespHeaders)
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/atomic"
+)
+
+// AsyncSink 在后台批量输出日志，请求只需要把日志放进缓冲区。
+// AsyncSink.Log 可以直接作为 NewBuilder 的参数
+type AsyncSink struct {
+	flush         func(batch []*AccessLog)
+	bufferSize    int
+	batchSize     int
+	flushInterval time.Duration
+	// 缓冲区满了的时候是阻塞还是丢弃
+	block bool
+
+	ch chan *AccessLog
+	// 关闭之后阻塞在 Log 里面的请求不再等待
+	stop chan struct{}
+	// 关闭之后不会再有新的日志，loop 输出剩下的日志之后退出
+	drain     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	// mu 保证 Close 返回之前正在放进缓冲区的日志都已经放进去了
+	mu      sync.RWMutex
+	closed  bool
+	dropped *atomic.Int64
+}
+
+// NewAsyncSink 默认缓冲 4096 条日志，每 100 条或者每秒调用一次 flush，缓冲区满了就丢弃。
+// flush 只会在一个 goroutine 里面调用，batch 在 flush 返回之后会被复用
+func NewAsyncSink(flush func(batch []*AccessLog), opts ...option.Option[AsyncSink]) *AsyncSink {
+	res := &AsyncSink{
+		flush:         flush,
+		bufferSize:    4096,
+		batchSize:     100,
+		flushInterval: time.Second,
+		stop:          make(chan struct{}),
+		drain:         make(chan struct{}),
+		done:          make(chan struct{}),
+		dropped:       atomic.NewInt64(0),
+	}
+	option.Apply[AsyncSink](res, opts...)
+	res.ch = make(chan *AccessLog, res.bufferSize)
+	go res.loop()
+	return res
+}
+
+// WithBufferSize 缓冲区最多保存多少条日志
+func WithBufferSize(size int) option.Option[AsyncSink] {
+	return func(s *AsyncSink) {
+		s.bufferSize = size
+	}
+}
+
+// WithBatch 攒够 size 条或者距离上一次输出超过 interval 就输出一次
+func WithBatch(size int, interval time.Duration) option.Option[AsyncSink] {
+	return func(s *AsyncSink) {
+		s.batchSize = size
+		s.flushInterval = interval
+	}
+}
+
+// WithBlock 缓冲区满了的时候阻塞请求，直到有空位或者请求结束，默认是丢弃
+func WithBlock() option.Option[AsyncSink] {
+	return func(s *AsyncSink) {
+		s.block = true
+	}
+}
+
+// WriterFlush 使用 f 格式化之后一次性写入 w，一条日志一行
+func WriterFlush(w io.Writer, f Formatter) func(batch []*AccessLog) {
+	var buf []byte
+	return func(batch []*AccessLog) {
+		buf = buf[:0]
+		for _, al := range batch {
+			buf = append(buf, f(al)...)
+			buf = append(buf, '\n')
+		}
+		_, _ = w.Write(buf)
+	}
+}
+
+// Log 把日志放进缓冲区，Close 之后的日志会被丢弃。
+// 阻塞模式下 ctx 是 *gin.Context 的时候等到请求的 context 结束，因为 gin.Context 的 Done 默认是 nil
+func (s *AsyncSink) Log(ctx context.Context, al *AccessLog) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Inc()
+		return
+	}
+	if !s.block {
+		select {
+		case s.ch <- al:
+		default:
+			s.dropped.Inc()
+		}
+		return
+	}
+	if gctx, ok := ctx.(*gin.Context); ok && gctx.Request != nil {
+		ctx = gctx.Request.Context()
+	}
+	select {
+	case s.ch <- al:
+	case <-ctx.Done():
+		s.dropped.Inc()
+	case <-s.stop:
+		s.dropped.Inc()
+	}
+}
+
+// Dropped 因为缓冲区满了或者已经关闭而丢弃的日志条数
+func (s *AsyncSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close 停止接收日志，并且输出缓冲区里面剩下的日志。
+// ctx 超时之前没有输出完就返回 ctx.Err()，剩下的日志会在后台继续输出
+func (s *AsyncSink) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		// 先唤醒阻塞的 Log，不然拿不到锁
+		close(s.stop)
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		close(s.drain)
+	})
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *AsyncSink) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*AccessLog, 0, s.batchSize)
+	output := func() {
+		if len(batch) > 0 {
+			s.flush(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case al := <-s.ch:
+			batch = append(batch, al)
+			if len(batch) >= s.batchSize {
+				output()
+			}
+		case <-ticker.C:
+			output()
+		case <-s.drain:
+			for {
+				select {
+				case al := <-s.ch:
+					batch = append(batch, al)
+					if len(batch) >= s.batchSize {
+						output()
+					}
+				default:
+					output()
+					return
+				}
+			}
+		}
+	}
+}
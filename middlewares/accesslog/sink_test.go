@@ -0,0 +1,210 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// batchRecorder 记录每一批日志的 Method
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]string
+	// 不为 nil 的时候，flush 会等到 release 关闭
+	release chan struct{}
+}
+
+func (r *batchRecorder) flush(batch []*AccessLog) {
+	if r.release != nil {
+		<-r.release
+	}
+	methods := make([]string, 0, len(batch))
+	for _, al := range batch {
+		methods = append(methods, al.Method)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, methods)
+}
+
+func (r *batchRecorder) get() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches
+}
+
+func TestAsyncSink_Batch(t *testing.T) {
+	r := &batchRecorder{}
+	s := NewAsyncSink(r.flush, WithBatch(2, time.Hour))
+	ctx := context.Background()
+	s.Log(ctx, &AccessLog{Method: "1"})
+	s.Log(ctx, &AccessLog{Method: "2"})
+	s.Log(ctx, &AccessLog{Method: "3"})
+	assert.Eventually(t, func() bool {
+		return len(r.get()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, [][]string{{"1", "2"}}, r.get())
+
+	// 关闭的时候输出剩下的日志
+	require.NoError(t, s.Close(ctx))
+	assert.Equal(t, [][]string{{"1", "2"}, {"3"}}, r.get())
+
+	s.Log(ctx, &AccessLog{Method: "4"})
+	assert.Equal(t, int64(1), s.Dropped())
+	require.NoError(t, s.Close(ctx))
+}
+
+func TestAsyncSink_Interval(t *testing.T) {
+	r := &batchRecorder{}
+	s := NewAsyncSink(r.flush, WithBatch(100, 10*time.Millisecond))
+	s.Log(context.Background(), &AccessLog{Method: "1"})
+	assert.Eventually(t, func() bool {
+		return len(r.get()) == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, s.Close(context.Background()))
+}
+
+func TestAsyncSink_Full(t *testing.T) {
+	testCases := []struct {
+		name        string
+		opts        []option.Option[AsyncSink]
+		wantDropped int64
+	}{
+		{
+			name:        "丢弃",
+			wantDropped: 1,
+		},
+		{
+			name:        "阻塞直到请求结束",
+			opts:        []option.Option[AsyncSink]{WithBlock()},
+			wantDropped: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &batchRecorder{release: make(chan struct{})}
+			opts := []option.Option[AsyncSink]{WithBufferSize(1), WithBatch(1, time.Hour)}
+			s := NewAsyncSink(r.flush, append(opts, tc.opts...)...)
+			// 第一条被取走之后卡在 flush，第二条在缓冲区里面
+			s.Log(context.Background(), &AccessLog{Method: "1"})
+			assert.Eventually(t, func() bool {
+				return len(s.ch) == 0
+			}, time.Second, time.Millisecond)
+			s.Log(context.Background(), &AccessLog{Method: "2"})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			s.Log(ctx, &AccessLog{Method: "3"})
+			assert.Equal(t, tc.wantDropped, s.Dropped())
+
+			close(r.release)
+			require.NoError(t, s.Close(context.Background()))
+			assert.Equal(t, [][]string{{"1"}, {"2"}}, r.get())
+		})
+	}
+}
+
+func TestAsyncSink_BlockGinContext(t *testing.T) {
+	r := &batchRecorder{release: make(chan struct{})}
+	s := NewAsyncSink(r.flush, WithBufferSize(1), WithBatch(1, time.Hour), WithBlock())
+	s.Log(context.Background(), &AccessLog{Method: "1"})
+	assert.Eventually(t, func() bool {
+		return len(s.ch) == 0
+	}, time.Second, time.Millisecond)
+	s.Log(context.Background(), &AccessLog{Method: "2"})
+
+	// gin.Context 的 Done 是 nil，要等到请求的 context 结束
+	reqCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(reqCtx)
+	s.Log(ctx, &AccessLog{Method: "3"})
+	assert.Equal(t, int64(1), s.Dropped())
+
+	close(r.release)
+	require.NoError(t, s.Close(context.Background()))
+}
+
+func TestAsyncSink_CloseConcurrently(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []option.Option[AsyncSink]
+	}{
+		{
+			name: "丢弃",
+		},
+		{
+			name: "阻塞",
+			opts: []option.Option[AsyncSink]{WithBlock()},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var flushed atomic.Int64
+			s := NewAsyncSink(func(batch []*AccessLog) {
+				flushed.Add(int64(len(batch)))
+			}, append([]option.Option[AsyncSink]{WithBufferSize(4), WithBatch(2, time.Hour)}, tc.opts...)...)
+			const total = 1000
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < total/10; j++ {
+						s.Log(context.Background(), &AccessLog{})
+					}
+				}()
+			}
+			time.Sleep(time.Millisecond)
+			require.NoError(t, s.Close(context.Background()))
+			wg.Wait()
+			// 每一条日志要么输出了，要么算在 Dropped 里面
+			assert.Equal(t, int64(total), flushed.Load()+s.Dropped())
+		})
+	}
+}
+
+func TestAsyncSink_CloseTimeout(t *testing.T) {
+	r := &batchRecorder{release: make(chan struct{})}
+	s := NewAsyncSink(r.flush, WithBatch(1, time.Hour))
+	s.Log(context.Background(), &AccessLog{Method: "1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Close(ctx))
+	close(r.release)
+	require.NoError(t, s.Close(context.Background()))
+}
+
+func TestWriterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	fn := WriterFlush(&buf, func(al *AccessLog) []byte {
+		return []byte(al.Method)
+	})
+	fn([]*AccessLog{{Method: "GET"}, {Method: "POST"}})
+	fn([]*AccessLog{{Method: "PUT"}})
+	assert.Equal(t, "GET\nPOST\nPUT\n", buf.String())
+}
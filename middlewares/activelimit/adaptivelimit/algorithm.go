@@ -0,0 +1,187 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adaptivelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+// Sample 一个请求结束之后的观测结果
+type Sample struct {
+	// RTT 请求的处理时间
+	RTT time.Duration
+	// InFlight 这个请求开始的时候的活跃个数，包括它自己
+	InFlight int64
+	// Dropped 请求因为过载失败了，例如超时或者下游返回 503
+	Dropped bool
+}
+
+// Algorithm 根据观测结果调整并发的限制，实现需要是并发安全的
+type Algorithm interface {
+	// Limit 当前的限制
+	Limit() int64
+	// Update 根据一个请求的观测结果更新限制，返回新的限制
+	Update(s Sample) int64
+}
+
+type bounds struct {
+	limit    float64
+	minLimit float64
+	maxLimit float64
+}
+
+func newBounds(initial, minLimit, maxLimit int64) bounds {
+	return bounds{
+		limit:    float64(initial),
+		minLimit: float64(minLimit),
+		maxLimit: float64(maxLimit),
+	}
+}
+
+func (b *bounds) clamp(limit float64) {
+	b.limit = math.Max(b.minLimit, math.Min(b.maxLimit, limit))
+}
+
+// Gradient 按照延迟的梯度调整限制。
+// 用长期的平均延迟作为基准，短期的延迟比基准高说明出现了排队，就按照比例缩小限制；
+// 延迟正常的时候每次增加 sqrt(limit)，给排队留出余量
+type Gradient struct {
+	mu sync.Mutex
+	bounds
+	// 长期的平均延迟，单位纳秒
+	longRTT float64
+	// 计算长期平均延迟的样本数
+	window float64
+	// 延迟超过基准多少倍才认为是出现了排队
+	tolerance float64
+	// 新的限制占多少比重，避免抖动
+	smoothing float64
+}
+
+// NewGradient initial 是初始的限制，限制会在 [minLimit, maxLimit] 之间调整
+func NewGradient(initial, minLimit, maxLimit int64, opts ...option.Option[Gradient]) *Gradient {
+	res := &Gradient{
+		bounds:    newBounds(initial, minLimit, maxLimit),
+		window:    600,
+		tolerance: 1.5,
+		smoothing: 0.2,
+	}
+	option.Apply[Gradient](res, opts...)
+	return res
+}
+
+// WithTolerance 延迟超过基准 tolerance 倍之后才开始缩小限制，默认 1.5
+func WithTolerance(tolerance float64) option.Option[Gradient] {
+	return func(g *Gradient) {
+		g.tolerance = tolerance
+	}
+}
+
+// WithSmoothing 每次调整的时候新的限制占多少比重，默认 0.2
+func WithSmoothing(smoothing float64) option.Option[Gradient] {
+	return func(g *Gradient) {
+		g.smoothing = smoothing
+	}
+}
+
+func (g *Gradient) Limit() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return int64(g.limit)
+}
+
+func (g *Gradient) Update(s Sample) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rtt := float64(s.RTT)
+	if rtt <= 0 {
+		return int64(g.limit)
+	}
+	if g.longRTT == 0 {
+		g.longRTT = rtt
+	} else {
+		g.longRTT += (rtt - g.longRTT) / g.window
+	}
+	// 延迟长期偏低的时候让基准更快地降下来，否则基准会一直停留在过载时候的延迟上
+	if g.longRTT/rtt > 2 {
+		g.longRTT *= 0.95
+	}
+	// 请求太少，延迟说明不了什么，也没有必要扩大限制
+	if float64(s.InFlight) < g.limit/2 {
+		return int64(g.limit)
+	}
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRTT/rtt))
+	if s.Dropped {
+		gradient = 0.5
+	}
+	newLimit := g.limit*gradient + math.Sqrt(g.limit)
+	g.clamp(g.limit*(1-g.smoothing) + newLimit*g.smoothing)
+	return int64(g.limit)
+}
+
+// AIMD 加性增，乘性减。
+// 延迟超过阈值或者请求失败的时候把限制乘以 backoff，否则限制加一
+type AIMD struct {
+	mu sync.Mutex
+	bounds
+	threshold time.Duration
+	backoff   float64
+}
+
+// NewAIMD initial 是初始的限制，限制会在 [minLimit, maxLimit] 之间调整
+func NewAIMD(initial, minLimit, maxLimit int64, opts ...option.Option[AIMD]) *AIMD {
+	res := &AIMD{
+		bounds:    newBounds(initial, minLimit, maxLimit),
+		threshold: time.Second,
+		backoff:   0.9,
+	}
+	option.Apply[AIMD](res, opts...)
+	return res
+}
+
+// WithLatencyThreshold 延迟超过 threshold 就缩小限制，默认 1 秒
+func WithLatencyThreshold(threshold time.Duration) option.Option[AIMD] {
+	return func(a *AIMD) {
+		a.threshold = threshold
+	}
+}
+
+// WithBackoff 缩小限制的比例，默认 0.9
+func WithBackoff(backoff float64) option.Option[AIMD] {
+	return func(a *AIMD) {
+		a.backoff = backoff
+	}
+}
+
+func (a *AIMD) Limit() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int64(a.limit)
+}
+
+func (a *AIMD) Update(s Sample) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s.Dropped || s.RTT > a.threshold {
+		a.clamp(a.limit * a.backoff)
+	} else if float64(s.InFlight)*2 >= a.limit {
+		a.clamp(a.limit + 1)
+	}
+	return int64(a.limit)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adaptivelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGradient(t *testing.T) {
+	g := NewGradient(10, 5, 20)
+	// 请求太少的时候不会扩大
+	assert.Equal(t, int64(10), g.Update(Sample{RTT: 10 * time.Millisecond, InFlight: 1}))
+
+	// 延迟稳定的时候逐渐扩大，不超过最大值
+	var limit int64
+	for i := 0; i < 100; i++ {
+		limit = g.Update(Sample{RTT: 10 * time.Millisecond, InFlight: limit + 10})
+	}
+	assert.Equal(t, int64(20), limit)
+
+	// 延迟升高之后缩小，不低于最小值
+	prev := limit
+	limit = g.Update(Sample{RTT: 50 * time.Millisecond, InFlight: 20})
+	assert.Less(t, limit, prev)
+	for i := 0; i < 100; i++ {
+		limit = g.Update(Sample{RTT: 50 * time.Millisecond, InFlight: 20})
+	}
+	assert.Equal(t, int64(5), limit)
+	assert.Equal(t, int64(5), g.Limit())
+}
+
+func TestGradient_Dropped(t *testing.T) {
+	g := NewGradient(100, 1, 1000, WithSmoothing(1))
+	// 失败的请求直接减半，再加上 sqrt(limit)
+	assert.Equal(t, int64(60), g.Update(Sample{RTT: time.Millisecond, InFlight: 100, Dropped: true}))
+}
+
+func TestAIMD(t *testing.T) {
+	a := NewAIMD(10, 5, 12, WithLatencyThreshold(100*time.Millisecond), WithBackoff(0.5))
+	testCases := []struct {
+		name      string
+		sample    Sample
+		wantLimit int64
+	}{
+		{
+			name:      "请求太少不扩大",
+			sample:    Sample{RTT: time.Millisecond, InFlight: 4},
+			wantLimit: 10,
+		},
+		{
+			name:      "加一",
+			sample:    Sample{RTT: time.Millisecond, InFlight: 5},
+			wantLimit: 11,
+		},
+		{
+			name:      "加一",
+			sample:    Sample{RTT: time.Millisecond, InFlight: 11},
+			wantLimit: 12,
+		},
+		{
+			name:      "不超过最大值",
+			sample:    Sample{RTT: time.Millisecond, InFlight: 12},
+			wantLimit: 12,
+		},
+		{
+			name:      "延迟超过阈值",
+			sample:    Sample{RTT: time.Second, InFlight: 12},
+			wantLimit: 6,
+		},
+		{
+			name:      "失败，不低于最小值",
+			sample:    Sample{RTT: time.Millisecond, InFlight: 6, Dropped: true},
+			wantLimit: 5,
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.wantLimit, a.Update(tc.sample), tc.name)
+	}
+	assert.Equal(t, int64(5), a.Limit())
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adaptivelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/atomic"
+)
+
+// AdaptiveActiveLimit 自适应的并发限制。
+// 和 locallimit.LocalActiveLimit 一样限制同时处理的请求数，
+// 但是限制不是固定的，而是由 Algorithm 根据每个请求的延迟调整
+type AdaptiveActiveLimit struct {
+	algorithm Algorithm
+	limit     *atomic.Int64
+	inFlight  *atomic.Int64
+	nowFunc   func() time.Time
+}
+
+// NewAdaptiveActiveLimit 默认使用 NewGradient(20, 1, 1000)
+func NewAdaptiveActiveLimit(algorithm Algorithm) *AdaptiveActiveLimit {
+	if algorithm == nil {
+		algorithm = NewGradient(20, 1, 1000)
+	}
+	return &AdaptiveActiveLimit{
+		algorithm: algorithm,
+		limit:     atomic.NewInt64(algorithm.Limit()),
+		inFlight:  atomic.NewInt64(0),
+		nowFunc:   time.Now,
+	}
+}
+
+// Limit 当前的并发限制，用于监控
+func (a *AdaptiveActiveLimit) Limit() int64 {
+	return a.limit.Load()
+}
+
+// InFlight 正在处理的请求数，用于监控
+func (a *AdaptiveActiveLimit) InFlight() int64 {
+	return a.inFlight.Load()
+}
+
+func (a *AdaptiveActiveLimit) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		current := a.inFlight.Add(1)
+		defer a.inFlight.Sub(1)
+		if current > a.limit.Load() {
+			ctx.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		start := a.nowFunc()
+		ctx.Next()
+		a.limit.Store(a.algorithm.Update(Sample{
+			RTT:      a.nowFunc().Sub(start),
+			InFlight: current,
+			Dropped:  dropped(ctx),
+		}))
+	}
+}
+
+// dropped 超时或者下游过载导致的失败，说明并发太高了
+func dropped(ctx *gin.Context) bool {
+	switch ctx.Writer.Status() {
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return errors.Is(ctx.Request.Context().Err(), context.DeadlineExceeded)
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adaptivelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveActiveLimit_Build(t *testing.T) {
+	now := time.UnixMilli(1695571200000)
+	limit := NewAdaptiveActiveLimit(NewAIMD(2, 1, 10,
+		WithLatencyThreshold(100*time.Millisecond), WithBackoff(0.5)))
+	limit.nowFunc = func() time.Time {
+		return now
+	}
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		assert.Equal(t, int64(1), limit.InFlight())
+		// 模拟处理请求花的时间
+		cost, err := time.ParseDuration(c.Query("cost"))
+		require.NoError(t, err)
+		now = now.Add(cost)
+		code, _ := strconv.Atoi(c.DefaultQuery("code", "204"))
+		c.Status(code)
+	})
+	serve := func(query string) int {
+		req, err := http.NewRequest(http.MethodGet, "/?"+query, nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve("cost=10ms"))
+	assert.Equal(t, int64(3), limit.Limit())
+	assert.Equal(t, http.StatusNoContent, serve("cost=200ms"))
+	assert.Equal(t, int64(1), limit.Limit())
+	assert.Equal(t, http.StatusNoContent, serve("cost=10ms"))
+	assert.Equal(t, int64(2), limit.Limit())
+	assert.Equal(t, http.StatusServiceUnavailable, serve("cost=10ms&code=503"))
+	assert.Equal(t, int64(1), limit.Limit())
+	assert.Equal(t, int64(0), limit.InFlight())
+
+	// 超过限制
+	limit.inFlight.Store(1)
+	assert.Equal(t, http.StatusTooManyRequests, serve("cost=10ms"))
+	assert.Equal(t, int64(1), limit.InFlight())
+}
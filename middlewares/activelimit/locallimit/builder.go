@@ -15,7 +15,10 @@
 package locallimit
 
 import (
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/atomic"
@@ -26,6 +29,23 @@ type LocalActiveLimit struct {
 	maxActive *atomic.Int64
 	// 当前活跃个数
 	countActive *atomic.Int64
+
+	// 影子模式下只记录，不限流
+	shadow   *atomic.Bool
+	shadowFn func(ctx *gin.Context, key string, current, maxActive int64)
+
+	// 为 nil 的时候只有全局的限制
+	keyFn     func(ctx *gin.Context) string
+	maxPerKey *atomic.Int64
+	keys      *keyCounts
+
+	// 排队模式，maxQueue 为 0 的时候超过 maxActive 直接拒绝
+	maxQueue     int
+	queueTimeout time.Duration
+	priorityFn   PriorityFunc
+	mu           sync.Mutex
+	waiters      waiterHeap
+	seq          uint64
 }
 
 // NewLocalActiveLimit 全局限流
@@ -33,16 +53,104 @@ func NewLocalActiveLimit(maxActive int64) *LocalActiveLimit {
 	return &LocalActiveLimit{
 		maxActive:   atomic.NewInt64(maxActive),
 		countActive: atomic.NewInt64(0),
+		shadow:      atomic.NewBool(false),
+		maxPerKey:   atomic.NewInt64(0),
+		keys:        newKeyCounts(),
+		shadowFn: func(ctx *gin.Context, key string, current, maxActive int64) {
+			log.Println("影子模式，请求本来会被限流", key, current, maxActive)
+		},
 	}
 }
 
+// SetMaxActive 修改最大活跃个数，可以在运行期间调用。
+// 调小之后已经在处理的请求不受影响，新的请求要等活跃个数降下来才会放行
+func (a *LocalActiveLimit) SetMaxActive(maxActive int64) *LocalActiveLimit {
+	a.maxActive.Store(maxActive)
+	// 调大之后排队的请求不需要等到有请求结束
+	a.mu.Lock()
+	a.dispatch()
+	a.mu.Unlock()
+	return a
+}
+
+// SetKeyGenFunc 按照 key 限制活跃个数，例如用户、路由或者租户，需要在 Build 之前调用。
+// 每个 key 最多 SetMaxActivePerKey 个活跃请求，同时所有请求加起来不能超过 maxActive。
+// 返回空字符串的请求只受全局的限制
+func (a *LocalActiveLimit) SetKeyGenFunc(fn func(ctx *gin.Context) string) *LocalActiveLimit {
+	a.keyFn = fn
+	return a
+}
+
+// SetMaxActivePerKey 每个 key 的最大活跃个数，可以在运行期间调用。
+// 小于等于 0 表示不限制单个 key，默认不限制
+func (a *LocalActiveLimit) SetMaxActivePerKey(maxActive int64) *LocalActiveLimit {
+	a.maxPerKey.Store(maxActive)
+	return a
+}
+
+// SetQueue 开启排队模式，需要在 Build 之前调用。
+// 超过 maxActive 的请求最多 maxQueue 个排队，等到有空闲的名额再处理，
+// 队列满了、等待超过 timeout 或者客户端断开连接的请求返回 429
+func (a *LocalActiveLimit) SetQueue(maxQueue int, timeout time.Duration) *LocalActiveLimit {
+	a.maxQueue = maxQueue
+	a.queueTimeout = timeout
+	return a
+}
+
+// SetPriorityFunc 设置排队的优先级，默认先来后到，需要在 Build 之前调用
+func (a *LocalActiveLimit) SetPriorityFunc(fn PriorityFunc) *LocalActiveLimit {
+	a.priorityFn = fn
+	return a
+}
+
+// SetShadowMode 开启或者关闭影子模式，可以在运行期间调用。
+// 影子模式下超过 maxActive 的请求也会放行，同时回调 shadowFn
+func (a *LocalActiveLimit) SetShadowMode(enabled bool) *LocalActiveLimit {
+	a.shadow.Store(enabled)
+	return a
+}
+
+// SetShadowHook current 是算上这个请求之后的活跃个数。
+// 超过单个 key 的限制的时候 key 是 SetKeyGenFunc 返回的 key，超过全局的限制的时候是空字符串
+func (a *LocalActiveLimit) SetShadowHook(fn func(ctx *gin.Context, key string, current, maxActive int64)) *LocalActiveLimit {
+	a.shadowFn = fn
+	return a
+}
+
 func (a *LocalActiveLimit) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if a.keyFn != nil {
+			if key := a.keyFn(ctx); key != "" {
+				current := a.keys.add(key)
+				defer a.keys.sub(key)
+				// 单个 key 超过限制的请求不排队，避免一个 key 占满队列
+				if maxActive := a.maxPerKey.Load(); maxActive > 0 && current > maxActive {
+					if !a.shadow.Load() {
+						ctx.AbortWithStatus(http.StatusTooManyRequests)
+						return
+					}
+					a.shadowFn(ctx, key, current, maxActive)
+				}
+			}
+		}
+		// 影子模式下不排队
+		if a.maxQueue > 0 && !a.shadow.Load() {
+			a.queued(ctx)
+			return
+		}
 		current := a.countActive.Add(1)
 		defer func() {
-			a.countActive.Sub(1)
+			if a.maxQueue > 0 {
+				a.release()
+			} else {
+				a.countActive.Sub(1)
+			}
 		}()
-		if current <= a.maxActive.Load() {
+		maxActive := a.maxActive.Load()
+		if current <= maxActive {
+			ctx.Next()
+		} else if a.shadow.Load() {
+			a.shadowFn(ctx, "", current, maxActive)
 			ctx.Next()
 		} else {
 			ctx.AbortWithStatus(http.StatusTooManyRequests)
@@ -15,9 +15,13 @@
 package locallimit
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -76,3 +80,239 @@ func TestLocalActiveLimit_Build(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalActiveLimit_SetShadowMode(t *testing.T) {
+	var hooked []int64
+	limit := NewLocalActiveLimit(1).
+		SetShadowMode(true).
+		SetShadowHook(func(ctx *gin.Context, key string, current, maxActive int64) {
+			assert.Equal(t, "", key)
+			hooked = append(hooked, current, maxActive)
+		})
+	limit.countActive.Store(1)
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	// 关闭影子模式之后立刻生效
+	for _, code := range []int{http.StatusNoContent, http.StatusTooManyRequests} {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, code, recorder.Code)
+		limit.SetShadowMode(false)
+	}
+	assert.Equal(t, []int64{2, 1}, hooked)
+}
+
+func TestLocalActiveLimit_SetMaxActive(t *testing.T) {
+	limit := NewLocalActiveLimit(1)
+	limit.countActive.Store(1)
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusNoContent} {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, code, recorder.Code)
+		limit.SetMaxActive(2)
+	}
+}
+
+func TestLocalActiveLimit_SetQueue(t *testing.T) {
+	limit := NewLocalActiveLimit(1).
+		SetQueue(2, time.Minute).
+		SetPriorityFunc(func(ctx *gin.Context) int {
+			priority, _ := strconv.Atoi(ctx.GetHeader("X-Priority"))
+			return priority
+		})
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	unblock := make(chan struct{})
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		mu.Lock()
+		order = append(order, c.Query("id"))
+		mu.Unlock()
+		<-unblock
+		c.Status(http.StatusNoContent)
+	})
+	serve := func(id string, priority int) int {
+		req, err := http.NewRequest(http.MethodGet, "/?id="+id, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Priority", strconv.Itoa(priority))
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	start := func(i int, id string, priority int, wantQueued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve(id, priority)
+		}()
+		assert.Eventually(t, func() bool {
+			return queueLen(limit) == wantQueued && limit.countActive.Load() == 1
+		}, time.Second, time.Millisecond)
+	}
+	start(0, "a", 0, 0)
+	start(1, "b", 0, 1)
+	start(2, "c", 1, 2)
+	// 队列已经满了
+	assert.Equal(t, http.StatusTooManyRequests, serve("d", 1))
+
+	for i := 0; i < 3; i++ {
+		unblock <- struct{}{}
+	}
+	wg.Wait()
+	assert.Equal(t, []int{http.StatusNoContent, http.StatusNoContent, http.StatusNoContent}, codes)
+	// 优先级高的先处理
+	assert.Equal(t, []string{"a", "c", "b"}, order)
+	assert.Equal(t, int64(0), limit.countActive.Load())
+}
+
+func TestLocalActiveLimit_QueueWait(t *testing.T) {
+	tests := []struct {
+		name string
+		// 排队之后做什么
+		after func(limit *LocalActiveLimit, cancel context.CancelFunc)
+
+		wantCode int
+	}{
+		{
+			name:     "超时",
+			after:    func(limit *LocalActiveLimit, cancel context.CancelFunc) {},
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "客户端断开连接",
+			after: func(limit *LocalActiveLimit, cancel context.CancelFunc) {
+				cancel()
+			},
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "调大 maxActive",
+			after: func(limit *LocalActiveLimit, cancel context.CancelFunc) {
+				limit.SetMaxActive(2)
+			},
+			wantCode: http.StatusNoContent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := NewLocalActiveLimit(1).SetQueue(1, 200*time.Millisecond)
+			// 已经有一个请求在处理了
+			limit.countActive.Store(1)
+			server := gin.Default()
+			server.Use(limit.Build())
+			server.GET("/", func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			recorder := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server.ServeHTTP(recorder, req)
+			}()
+			assert.Eventually(t, func() bool {
+				return queueLen(limit) == 1
+			}, time.Second, time.Millisecond)
+			tt.after(limit, cancel)
+			<-done
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, 0, queueLen(limit))
+			assert.Equal(t, int64(1), limit.countActive.Load())
+		})
+	}
+}
+
+func queueLen(limit *LocalActiveLimit) int {
+	limit.mu.Lock()
+	defer limit.mu.Unlock()
+	return limit.waiters.Len()
+}
+
+func TestLocalActiveLimit_SetKeyGenFunc(t *testing.T) {
+	limit := NewLocalActiveLimit(2).
+		SetKeyGenFunc(func(ctx *gin.Context) string {
+			return ctx.GetHeader("X-Uid")
+		}).
+		SetMaxActivePerKey(1)
+	unblock := make(chan struct{})
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		if c.Query("block") == "true" {
+			<-unblock
+		}
+		c.Status(http.StatusNoContent)
+	})
+	serve := func(uid string, block bool) int {
+		req, err := http.NewRequest(http.MethodGet, "/?block="+strconv.FormatBool(block), nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Uid", uid)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusNoContent, serve("123", true))
+	}()
+	assert.Eventually(t, func() bool {
+		return limit.countActive.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	// 同一个用户超过了限制，别的用户不受影响
+	assert.Equal(t, http.StatusTooManyRequests, serve("123", false))
+	assert.Equal(t, http.StatusNoContent, serve("456", false))
+	// 没有 key 的请求只受全局的限制
+	assert.Equal(t, http.StatusNoContent, serve("", false))
+
+	// 全局超过限制
+	limit.SetMaxActive(1)
+	assert.Equal(t, http.StatusTooManyRequests, serve("456", false))
+
+	// 影子模式下回调的是请求的 key
+	var shadowKeys []string
+	limit.SetMaxActive(2).SetShadowMode(true).
+		SetShadowHook(func(ctx *gin.Context, key string, current, maxActive int64) {
+			shadowKeys = append(shadowKeys, key)
+		})
+	assert.Equal(t, http.StatusNoContent, serve("123", false))
+	assert.Equal(t, []string{"123"}, shadowKeys)
+	limit.SetShadowMode(false)
+
+	// 小于等于 0 不限制单个 key
+	limit.SetMaxActivePerKey(0)
+	assert.Equal(t, http.StatusNoContent, serve("123", false))
+
+	close(unblock)
+	wg.Wait()
+	// 没有活跃请求的 key 都被删掉了
+	assert.Equal(t, 0, limit.keys.len())
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locallimit
+
+import "sync"
+
+const keyShards = 32
+
+// keyCounts 记录每个 key 的活跃个数。
+// 个数降到 0 的时候就删掉，所以只会保存正在处理请求的 key，不需要另外清理
+type keyCounts struct {
+	shards [keyShards]keyShard
+}
+
+type keyShard struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newKeyCounts() *keyCounts {
+	res := &keyCounts{}
+	for i := range res.shards {
+		res.shards[i].counts = make(map[string]int64)
+	}
+	return res
+}
+
+// add 加一，返回加一之后的个数
+func (k *keyCounts) add(key string) int64 {
+	shard := k.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.counts[key]++
+	return shard.counts[key]
+}
+
+func (k *keyCounts) sub(key string) {
+	shard := k.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.counts[key] <= 1 {
+		delete(shard.counts, key)
+		return
+	}
+	shard.counts[key]--
+}
+
+// len 有活跃请求的 key 的个数
+func (k *keyCounts) len() int {
+	var res int
+	for i := range k.shards {
+		shard := &k.shards[i]
+		shard.mu.Lock()
+		res += len(shard.counts)
+		shard.mu.Unlock()
+	}
+	return res
+}
+
+func (k *keyCounts) shard(key string) *keyShard {
+	// FNV-1a
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &k.shards[hash%keyShards]
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locallimit
+
+import (
+	"container/heap"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ecodeclub/ginx/session"
+)
+
+// PriorityFunc 计算请求排队的优先级，数字越大越优先，优先级相同的按照先来后到
+type PriorityFunc func(ctx *gin.Context) int
+
+// PriorityByLogin 登录的用户排在匿名用户前面.
+// 需要放在登录校验的 middleware 之后
+func PriorityByLogin() PriorityFunc {
+	return func(ctx *gin.Context) int {
+		if _, ok := ctx.Get(session.CtxSessionKey); ok {
+			return 1
+		}
+		return 0
+	}
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	// 拿到名额之后关闭
+	ready chan struct{}
+	// 在堆里面的下标，出队之后是 -1
+	index int
+}
+
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*h = old[:n-1]
+	return w
+}
+
+// queued 排队模式下，只有拿到名额的请求才会计入 countActive
+func (a *LocalActiveLimit) queued(ctx *gin.Context) {
+	w, ok := a.enqueue(ctx)
+	if !ok || (w != nil && !a.wait(ctx, w)) {
+		ctx.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	defer a.release()
+	ctx.Next()
+}
+
+// enqueue 有空闲的名额并且没有人在排队的时候直接拿到名额，返回 nil；
+// 否则进入队列，队列满了返回 false
+func (a *LocalActiveLimit) enqueue(ctx *gin.Context) (*waiter, bool) {
+	priority := 0
+	if a.priorityFn != nil {
+		priority = a.priorityFn(ctx)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.waiters.Len() == 0 && a.countActive.Load() < a.maxActive.Load() {
+		a.countActive.Add(1)
+		return nil, true
+	}
+	if a.waiters.Len() >= a.maxQueue {
+		return nil, false
+	}
+	a.seq++
+	w := &waiter{priority: priority, seq: a.seq, ready: make(chan struct{})}
+	heap.Push(&a.waiters, w)
+	return w, true
+}
+
+// wait 等到拿到名额、超时或者客户端断开连接
+func (a *LocalActiveLimit) wait(ctx *gin.Context, w *waiter) bool {
+	timer := time.NewTimer(a.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Request.Context().Done():
+	}
+	a.mu.Lock()
+	granted := w.index < 0
+	if !granted {
+		heap.Remove(&a.waiters, w.index)
+	}
+	a.mu.Unlock()
+	if !granted {
+		return false
+	}
+	// 超时的同时拿到了名额，客户端还在就继续处理
+	if ctx.Request.Context().Err() != nil {
+		a.release()
+		return false
+	}
+	return true
+}
+
+func (a *LocalActiveLimit) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.countActive.Sub(1)
+	a.dispatch()
+}
+
+// dispatch 把空出来的名额交给排在最前面的请求，调用方需要持有锁
+func (a *LocalActiveLimit) dispatch() {
+	for a.waiters.Len() > 0 && a.countActive.Load() < a.maxActive.Load() {
+		w := heap.Pop(&a.waiters).(*waiter)
+		a.countActive.Add(1)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,44 @@
+-- 全局的租约，member 是请求 ID，score 是过期时间
+local key = KEYS[1]
+-- 当前实例的租约，用来观察每个实例各自处理了多少请求
+local instanceKey = KEYS[2]
+-- 可选，按照 key 限制的时候这个 key 的租约
+local perKey = KEYS[3]
+local member = ARGV[1]
+local ttl = tonumber(ARGV[2])
+local maxActive = tonumber(ARGV[3])
+-- 影子模式下超过限制也要记录租约，这样统计的才是真实的活跃个数
+local force = ARGV[4] == '1'
+local maxPerKey = tonumber(ARGV[5])
+-- 使用 redis 的时间，避免各个实例的时钟不一致导致租约被提前回收
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+-- 回收过期的租约，例如进程崩溃之后没有释放的
+for _, k in ipairs(KEYS) do
+    redis.call('ZREMRANGEBYSCORE', k, '-inf', now)
+end
+
+local cnt = redis.call('ZCARD', key) + 1
+local keyCnt = 0
+local acquired = 1
+if perKey then
+    keyCnt = redis.call('ZCARD', perKey) + 1
+    -- maxPerKey 小于等于 0 的时候不限制单个 key
+    if maxPerKey > 0 and keyCnt > maxPerKey then
+        acquired = 0
+    end
+end
+if cnt > maxActive then
+    acquired = 0
+end
+if acquired == 0 and not force then
+    return { 0, cnt, keyCnt }
+end
+-- 空闲的 key 过期之后会被删掉
+for _, k in ipairs(KEYS) do
+    redis.call('ZADD', k, now + ttl, member)
+    redis.call('PEXPIRE', k, ttl)
+end
+-- 返回: 是否拿到了租约, 算上这个请求之后的全局活跃个数, 这个 key 的活跃个数
+return { acquired, cnt, keyCnt }
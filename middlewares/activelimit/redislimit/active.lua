@@ -0,0 +1,8 @@
+-- 统计没有过期的租约个数，过期时间按照 redis 的时间计算
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local res = {}
+for i, key in ipairs(KEYS) do
+    res[i] = redis.call('ZCOUNT', key, '(' .. now, '+inf')
+end
+return res
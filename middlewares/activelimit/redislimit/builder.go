@@ -15,14 +15,54 @@
 package redislimit
 
 import (
+	"context"
+	_ "embed"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/atomic"
+
+	"github.com/ecodeclub/ginx/internal/breaker"
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+)
+
+var (
+	//go:embed acquire.lua
+	luaAcquire string
+	//go:embed renew.lua
+	luaRenew string
+	//go:embed release.lua
+	luaRelease string
+	//go:embed active.lua
+	luaActive string
 )
 
+// 释放和续约不使用请求的 context，请求被取消了也要释放
+const cleanupTimeout = time.Second
+
+// FailPolicy Redis 出错的时候怎么处理请求
+type FailPolicy = ratelimit.FailPolicy
+
+const (
+	// FailClosed 返回 500，默认策略
+	FailClosed = ratelimit.FailClosed
+	// FailOpen 直接放行
+	FailOpen = ratelimit.FailOpen
+	// FailFallback 降级为本地限流，需要调用 SetFallbackMaxActive
+	FailFallback = ratelimit.FailFallback
+)
+
+// RedisActiveLimit 使用租约记录活跃的请求。
+// 每个请求在 ZSET 中有一个租约，score 是过期时间，请求结束的时候释放，
+// 请求超过 leaseTTL 还没有结束会自动续约。
+// 进程崩溃没有释放的租约过期之后会被回收，不会一直占着名额
 type RedisActiveLimit struct {
 	// 最大限制个数
 	maxActive *atomic.Int64
@@ -31,18 +71,49 @@ type RedisActiveLimit struct {
 	key   string
 	cmd   redis.Cmdable
 	logFn func(msg any, args ...any)
+
+	instanceID string
+	seq        *atomic.Uint64
+	leaseTTL   time.Duration
+
+	// 为 nil 的时候只有全局的限制
+	keyFn     func(ctx *gin.Context) string
+	maxPerKey *atomic.Int64
+
+	policy FailPolicy
+	// 降级之后每个实例的最大活跃个数
+	fallbackMaxActive *atomic.Int64
+	// 降级之后当前实例的活跃个数
+	fallbackCountActive *atomic.Int64
+	breaker             *breaker.Breaker
+
+	// 影子模式下只记录，不限流
+	shadow   *atomic.Bool
+	shadowFn func(ctx *gin.Context, key string, current, maxActive int64)
 }
 
 // NewRedisActiveLimit 全局限流
 func NewRedisActiveLimit(cmd redis.Cmdable, maxActive int64, key string) *RedisActiveLimit {
-	return &RedisActiveLimit{
+	res := &RedisActiveLimit{
 		maxActive: atomic.NewInt64(maxActive),
 		key:       key,
 		cmd:       cmd,
 		logFn: func(msg any, args ...any) {
 			fmt.Printf("%v  详细信息: %v \n", msg, args)
 		},
+		instanceID:          uuid.NewString(),
+		seq:                 atomic.NewUint64(0),
+		leaseTTL:            10 * time.Second,
+		maxPerKey:           atomic.NewInt64(0),
+		fallbackMaxActive:   atomic.NewInt64(0),
+		fallbackCountActive: atomic.NewInt64(0),
+		breaker:             breaker.NewBreaker(),
+		shadow:              atomic.NewBool(false),
 	}
+	res.shadowFn = func(ctx *gin.Context, key string, current, maxActive int64) {
+		res.logFn("影子模式，请求本来会被限流", key, current, maxActive)
+	}
+	return res
 }
 
 func (a *RedisActiveLimit) SetLogFunc(fun func(msg any, args ...any)) *RedisActiveLimit {
@@ -50,25 +121,238 @@ func (a *RedisActiveLimit) SetLogFunc(fun func(msg any, args ...any)) *RedisActi
 	return a
 }
 
+// SetMaxActive 修改最大活跃个数，可以在运行期间调用。
+// 每个实例各自保存 maxActive，所以需要在所有的实例上都修改
+func (a *RedisActiveLimit) SetMaxActive(maxActive int64) *RedisActiveLimit {
+	a.maxActive.Store(maxActive)
+	return a
+}
+
+// SetInstanceID 设置实例的 ID，默认是随机生成的 UUID。
+// 每个实例的租约记录在 {key}:instance:{instanceID} 中，可以设置成 hostname 之类的方便观察
+func (a *RedisActiveLimit) SetInstanceID(id string) *RedisActiveLimit {
+	a.instanceID = id
+	return a
+}
+
+// SetLeaseTTL 设置租约的有效期，默认 10 秒。
+// 实例崩溃之后它的请求最多 ttl 之后被回收；没有结束的请求每 ttl/2 续约一次
+func (a *RedisActiveLimit) SetLeaseTTL(ttl time.Duration) *RedisActiveLimit {
+	a.leaseTTL = ttl
+	return a
+}
+
+// SetKeyGenFunc 按照 key 限制活跃个数，例如用户、路由或者租户，需要在 Build 之前调用。
+// 每个 key 最多 SetMaxActivePerKey 个活跃请求，同时所有请求加起来不能超过 maxActive。
+// 返回空字符串的请求只受全局的限制。
+// 每个 key 的租约记录在 {key}:key:{请求的 key} 中，没有活跃请求之后会被删掉
+func (a *RedisActiveLimit) SetKeyGenFunc(fn func(ctx *gin.Context) string) *RedisActiveLimit {
+	a.keyFn = fn
+	return a
+}
+
+// SetMaxActivePerKey 每个 key 的最大活跃个数，可以在运行期间调用。
+// 小于等于 0 表示不限制单个 key，默认不限制
+func (a *RedisActiveLimit) SetMaxActivePerKey(maxActive int64) *RedisActiveLimit {
+	a.maxPerKey.Store(maxActive)
+	return a
+}
+
+// SetFailPolicy 设置 Redis 出错时候的策略
+func (a *RedisActiveLimit) SetFailPolicy(policy FailPolicy) *RedisActiveLimit {
+	a.policy = policy
+	return a
+}
+
+// SetFallbackMaxActive 设置降级之后每个实例的最大活跃个数，同时把策略设置为 FailFallback。
+// 一般设置为 maxActive 除以实例数
+func (a *RedisActiveLimit) SetFallbackMaxActive(maxActive int64) *RedisActiveLimit {
+	a.fallbackMaxActive.Store(maxActive)
+	a.policy = FailFallback
+	return a
+}
+
+// SetCircuitBreaker Redis 连续出错 threshold 次之后熔断，
+// 熔断期间直接按照 FailPolicy 处理，cooldown 之后尝试恢复
+func (a *RedisActiveLimit) SetCircuitBreaker(threshold int, cooldown time.Duration) *RedisActiveLimit {
+	a.breaker = breaker.NewBreaker(breaker.WithThreshold(threshold), breaker.WithCooldown(cooldown))
+	return a
+}
+
+// SetShadowMode 开启或者关闭影子模式，可以在运行期间调用。
+// 影子模式下超过 maxActive 的请求也会放行，同时回调 shadowFn；Redis 出错的时候也直接放行
+func (a *RedisActiveLimit) SetShadowMode(enabled bool) *RedisActiveLimit {
+	a.shadow.Store(enabled)
+	return a
+}
+
+// SetShadowHook current 是算上这个请求之后的活跃个数，默认使用 logFn 记录下来。
+// 超过单个 key 的限制的时候 key 是 SetKeyGenFunc 返回的 key，超过全局的限制的时候是空字符串
+func (a *RedisActiveLimit) SetShadowHook(fn func(ctx *gin.Context, key string, current, maxActive int64)) *RedisActiveLimit {
+	a.shadowFn = fn
+	return a
+}
+
 func (a *RedisActiveLimit) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		currentCount, err := a.cmd.Incr(ctx, a.key).Result()
+		var (
+			member    = a.instanceID + ":" + strconv.FormatUint(a.seq.Add(1), 36)
+			reqKey    = a.requestKey(ctx)
+			keys      = a.keys(reqKey)
+			maxActive = a.maxActive.Load()
+			shadow    = a.shadow.Load()
+			res       acquireResult
+			err       = breaker.ErrOpen
+		)
+		if a.breaker.Allow() {
+			res, err = a.acquire(ctx, keys, member, maxActive, shadow)
+			// 请求自己取消了，不是 Redis 的问题
+			if err == nil || errors.Is(err, context.Canceled) {
+				a.breaker.Success()
+			} else {
+				a.breaker.Failure()
+			}
+		}
 		if err != nil {
-			// 为了安全性 直接返回异常
-			a.logFn("redis 加一操作", err)
-			ctx.AbortWithStatus(http.StatusInternalServerError)
+			a.logFn("redis 获取租约", err)
+			if shadow {
+				ctx.Next()
+				return
+			}
+			a.fail(ctx)
 			return
 		}
+		if !res.acquired && !shadow {
+			a.logFn("web server ", "限流中..")
+			ctx.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		stop := a.keepAlive(keys, member)
 		defer func() {
-			if err = a.cmd.Decr(ctx, a.key).Err(); err != nil {
-				a.logFn("redis 减一操作", err)
-			}
+			stop()
+			a.release(keys, member)
 		}()
-		if currentCount <= a.maxActive.Load() {
+		if !res.acquired {
+			if res.current > maxActive {
+				a.shadowFn(ctx, "", res.current, maxActive)
+			}
+			if maxPerKey := a.maxPerKey.Load(); len(keys) > 2 && maxPerKey > 0 && res.keyCurrent > maxPerKey {
+				a.shadowFn(ctx, reqKey, res.keyCurrent, maxPerKey)
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// Active 返回全局和当前实例的活跃个数，不包括已经过期的租约
+func (a *RedisActiveLimit) Active(ctx context.Context) (total int64, instance int64, err error) {
+	vals, err := a.cmd.Eval(ctx, luaActive, a.keys("")).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(vals) != 2 {
+		return 0, 0, fmt.Errorf("统计租约脚本返回值错误: %v", vals)
+	}
+	return vals[0], vals[1], nil
+}
+
+type acquireResult struct {
+	acquired bool
+	// 算上这个请求之后的全局活跃个数
+	current int64
+	// 算上这个请求之后这个 key 的活跃个数
+	keyCurrent int64
+}
+
+func (a *RedisActiveLimit) acquire(ctx context.Context, keys []string, member string,
+	maxActive int64, force bool) (acquireResult, error) {
+	forceArg := 0
+	if force {
+		forceArg = 1
+	}
+	vals, err := a.cmd.Eval(ctx, luaAcquire, keys, member,
+		a.leaseTTL.Milliseconds(), maxActive, forceArg, a.maxPerKey.Load()).Int64Slice()
+	if err != nil {
+		return acquireResult{}, err
+	}
+	if len(vals) != 3 {
+		return acquireResult{}, fmt.Errorf("获取租约脚本返回值错误: %v", vals)
+	}
+	return acquireResult{acquired: vals[0] == 1, current: vals[1], keyCurrent: vals[2]}, nil
+}
+
+// keepAlive 请求没有结束之前每 leaseTTL/2 续约一次，返回的方法用来停止续约
+func (a *RedisActiveLimit) keepAlive(keys []string, member string) func() {
+	var (
+		mu      sync.Mutex
+		stopped bool
+		timer   *time.Timer
+	)
+	interval := a.leaseTTL / 2
+	mu.Lock()
+	defer mu.Unlock()
+	timer = time.AfterFunc(interval, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		err := a.cmd.Eval(ctx, luaRenew, keys, member, a.leaseTTL.Milliseconds()).Err()
+		if err != nil {
+			a.logFn("redis 续约", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			timer.Reset(interval)
+		}
+	})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		timer.Stop()
+	}
+}
+
+func (a *RedisActiveLimit) release(keys []string, member string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := a.cmd.Eval(ctx, luaRelease, keys, member).Err(); err != nil {
+		// 释放失败的租约过期之后会被回收
+		a.logFn("redis 释放租约", err)
+	}
+}
+
+func (a *RedisActiveLimit) requestKey(ctx *gin.Context) string {
+	if a.keyFn == nil {
+		return ""
+	}
+	return a.keyFn(ctx)
+}
+
+// keys 使用 hash tag，保证在集群模式下所有的 key 在同一个 slot。
+// requestKey 不为空的时候第三个是这个 key 的租约
+func (a *RedisActiveLimit) keys(requestKey string) []string {
+	prefix := "{" + a.key + "}"
+	res := []string{prefix, prefix + ":instance:" + a.instanceID}
+	if requestKey != "" {
+		res = append(res, prefix+":key:"+requestKey)
+	}
+	return res
+}
+
+func (a *RedisActiveLimit) fail(ctx *gin.Context) {
+	switch a.policy {
+	case FailOpen:
+		ctx.Next()
+	case FailFallback:
+		current := a.fallbackCountActive.Add(1)
+		defer a.fallbackCountActive.Sub(1)
+		if current <= a.fallbackMaxActive.Load() {
 			ctx.Next()
 		} else {
-			a.logFn("web server ", "限流中..")
 			ctx.AbortWithStatus(http.StatusTooManyRequests)
 		}
+	default:
+		// 为了安全性 直接返回异常
+		ctx.AbortWithStatus(http.StatusInternalServerError)
 	}
 }
@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
@@ -47,11 +48,10 @@ func TestRedisActiveLimit_Build(t *testing.T) {
 			name: "正常通过",
 			mock: func(ctrl *gomock.Controller) redis.Cmdable {
 				cmd := mocks.NewMockCmdable(ctrl)
-				res := redis.NewIntCmd(context.Background())
-				res.SetVal(int64(1))
-				cmd.EXPECT().Incr(gomock.Any(), key).Return(res)
-				res = redis.NewIntCmd(context.Background())
-				cmd.EXPECT().Decr(gomock.Any(), key).Return(res)
+				cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(), gomock.Any()).
+					Return(evalResult([]any{int64(1), int64(1), int64(0)}, nil))
+				cmd.EXPECT().Eval(gomock.Any(), luaRelease, gomock.Any(), gomock.Any()).
+					Return(evalResult(int64(0), nil))
 				return cmd
 			},
 			reqBuilder: func(t *testing.T) *http.Request {
@@ -64,13 +64,10 @@ func TestRedisActiveLimit_Build(t *testing.T) {
 		{
 			name: "限流中",
 			mock: func(ctrl *gomock.Controller) redis.Cmdable {
-				ctx := context.Background()
 				cmd := mocks.NewMockCmdable(ctrl)
-				res := redis.NewIntCmd(ctx)
-				res.SetVal(int64(2))
-				cmd.EXPECT().Incr(gomock.Any(), key).Return(res)
-				res = redis.NewIntCmd(ctx)
-				cmd.EXPECT().Decr(gomock.Any(), key).Return(res)
+				// 没有拿到租约，不需要释放
+				cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(), gomock.Any()).
+					Return(evalResult([]any{int64(0), int64(2), int64(0)}, nil))
 				return cmd
 			},
 			reqBuilder: func(t *testing.T) *http.Request {
@@ -81,16 +78,13 @@ func TestRedisActiveLimit_Build(t *testing.T) {
 			wantCode: http.StatusTooManyRequests,
 		},
 		{
-			name: "defer 的减1操作失败",
+			name: "释放租约失败",
 			mock: func(ctrl *gomock.Controller) redis.Cmdable {
-				ctx := context.Background()
 				cmd := mocks.NewMockCmdable(ctrl)
-				res := redis.NewIntCmd(ctx)
-				res.SetVal(int64(1))
-				cmd.EXPECT().Incr(gomock.Any(), key).Return(res)
-				res = redis.NewIntCmd(ctx)
-				res.SetErr(errors.New("模拟 redis 操作失败"))
-				cmd.EXPECT().Decr(gomock.Any(), key).Return(res)
+				cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(), gomock.Any()).
+					Return(evalResult([]any{int64(1), int64(1), int64(0)}, nil))
+				cmd.EXPECT().Eval(gomock.Any(), luaRelease, gomock.Any(), gomock.Any()).
+					Return(evalResult(nil, errors.New("模拟 redis 操作失败")))
 				return cmd
 			},
 			reqBuilder: func(t *testing.T) *http.Request {
@@ -101,12 +95,11 @@ func TestRedisActiveLimit_Build(t *testing.T) {
 			wantCode: http.StatusNoContent,
 		},
 		{
-			name: "刚进入中间件的加1操作失败",
+			name: "获取租约失败",
 			mock: func(ctrl *gomock.Controller) redis.Cmdable {
 				cmd := mocks.NewMockCmdable(ctrl)
-				res := redis.NewIntCmd(context.Background())
-				res.SetErr(errors.New("模拟 redis 操作失败"))
-				cmd.EXPECT().Incr(gomock.Any(), key).Return(res)
+				cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(), gomock.Any()).
+					Return(evalResult(nil, errors.New("模拟 redis 操作失败")))
 				return cmd
 			},
 			reqBuilder: func(t *testing.T) *http.Request {
@@ -137,3 +130,245 @@ func TestRedisActiveLimit_Build(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisActiveLimit_lease(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	keys := []string{"{limit}", "{limit}:instance:host-1"}
+	renewed := make(chan struct{}, 10)
+	gomock.InOrder(
+		cmd.EXPECT().Eval(gomock.Any(), luaAcquire, keys,
+			"host-1:1", int64(20), int64(1), 0, int64(0)).
+			Return(evalResult([]any{int64(1), int64(1), int64(0)}, nil)),
+		cmd.EXPECT().Eval(gomock.Any(), luaRenew, keys, "host-1:1", int64(20)).
+			DoAndReturn(func(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+				renewed <- struct{}{}
+				return evalResult(int64(0), nil)
+			}).MinTimes(1),
+		// 请求被取消了也要释放
+		cmd.EXPECT().Eval(gomock.Any(), luaRelease, keys, "host-1:1").
+			DoAndReturn(func(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+				assert.NoError(t, ctx.Err())
+				return evalResult(int64(0), nil)
+			}),
+	)
+
+	limit := NewRedisActiveLimit(cmd, 1, "limit").
+		SetInstanceID("host-1").
+		SetLeaseTTL(20 * time.Millisecond)
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		// 处理的时间超过了租约的有效期
+		<-renewed
+		c.Status(http.StatusNoContent)
+	})
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	cancel()
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+}
+
+func evalResult(val any, err error) *redis.Cmd {
+	res := redis.NewCmd(context.Background())
+	if err != nil {
+		res.SetErr(err)
+	} else {
+		res.SetVal(val)
+	}
+	return res
+}
+
+func TestRedisActiveLimit_FailPolicy(t *testing.T) {
+	const key = "limit"
+	tests := []struct {
+		name string
+		// 连续多少次获取租约失败
+		incrCnt int
+		setup   func(limit *RedisActiveLimit)
+
+		wantCodes []int
+	}{
+		{
+			name:      "默认拒绝",
+			incrCnt:   1,
+			setup:     func(limit *RedisActiveLimit) {},
+			wantCodes: []int{http.StatusInternalServerError},
+		},
+		{
+			name:    "放行",
+			incrCnt: 1,
+			setup: func(limit *RedisActiveLimit) {
+				limit.SetFailPolicy(FailOpen)
+			},
+			wantCodes: []int{http.StatusNoContent},
+		},
+		{
+			name:    "降级为本地限流",
+			incrCnt: 1,
+			setup: func(limit *RedisActiveLimit) {
+				limit.SetFallbackMaxActive(1)
+			},
+			wantCodes: []int{http.StatusNoContent},
+		},
+		{
+			name:    "熔断之后不再访问 Redis",
+			incrCnt: 2,
+			setup: func(limit *RedisActiveLimit) {
+				limit.SetFailPolicy(FailOpen).SetCircuitBreaker(2, time.Minute)
+			},
+			wantCodes: []int{http.StatusNoContent, http.StatusNoContent, http.StatusNoContent},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(), gomock.Any()).
+				Return(evalResult(nil, errors.New("模拟 redis 操作失败"))).Times(tt.incrCnt)
+
+			limit := NewRedisActiveLimit(cmd, 1, key).
+				SetLogFunc(func(msg any, args ...any) {})
+			tt.setup(limit)
+			server := gin.Default()
+			server.Use(limit.Build())
+			server.GET("/", func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			for _, code := range tt.wantCodes {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, req)
+				assert.Equal(t, code, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestRedisActiveLimit_Fallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	cmd.EXPECT().Eval(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(evalResult(nil, errors.New("模拟 redis 操作失败"))).AnyTimes()
+
+	limit := NewRedisActiveLimit(cmd, 10, "limit").
+		SetLogFunc(func(msg any, args ...any) {}).
+		SetFallbackMaxActive(1)
+	server := gin.Default()
+	server.Use(limit.Build())
+	var innerCode int
+	server.GET("/outer", func(c *gin.Context) {
+		// 第一个请求还没有结束，本地的配额已经用完了
+		req, err := http.NewRequest(http.MethodGet, "/inner", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		innerCode = recorder.Code
+		c.Status(http.StatusNoContent)
+	})
+	server.GET("/inner", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req, err := http.NewRequest(http.MethodGet, "/outer", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, http.StatusTooManyRequests, innerCode)
+}
+
+func TestRedisActiveLimit_SetShadowMode(t *testing.T) {
+	const key = "limit"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	gomock.InOrder(
+		// 影子模式下超过限制也会记录租约
+		cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(),
+			gomock.Any(), gomock.Any(), int64(1), 1, int64(0)).
+			Return(evalResult([]any{int64(0), int64(2), int64(0)}, nil)),
+		cmd.EXPECT().Eval(gomock.Any(), luaRelease, gomock.Any(), gomock.Any()).
+			Return(evalResult(int64(0), nil)),
+		// Redis 出错也放行
+		cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(), gomock.Any()).
+			Return(evalResult(nil, errors.New("模拟 redis 操作失败"))),
+		cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(),
+			gomock.Any(), gomock.Any(), int64(1), 0, int64(0)).
+			Return(evalResult([]any{int64(0), int64(2), int64(0)}, nil)),
+	)
+
+	var hooked []int64
+	limit := NewRedisActiveLimit(cmd, 1, key).
+		SetLogFunc(func(msg any, args ...any) {}).
+		SetShadowMode(true).
+		SetShadowHook(func(ctx *gin.Context, k string, current, maxActive int64) {
+			// 超过的是全局的限制
+			assert.Equal(t, "", k)
+			hooked = append(hooked, current, maxActive)
+		})
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	for i, code := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests} {
+		if i == 2 {
+			limit.SetShadowMode(false)
+		}
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, code, recorder.Code)
+	}
+	assert.Equal(t, []int64{2, 1}, hooked)
+}
+
+func TestRedisActiveLimit_ShadowPerKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	gomock.InOrder(
+		cmd.EXPECT().Eval(gomock.Any(), luaAcquire, gomock.Any(),
+			gomock.Any(), gomock.Any(), int64(10), 1, int64(1)).
+			Return(evalResult([]any{int64(0), int64(2), int64(2)}, nil)),
+		cmd.EXPECT().Eval(gomock.Any(), luaRelease, gomock.Any(), gomock.Any()).
+			Return(evalResult(int64(0), nil)),
+	)
+
+	var keys []string
+	limit := NewRedisActiveLimit(cmd, 10, "limit").
+		SetLogFunc(func(msg any, args ...any) {}).
+		SetKeyGenFunc(func(ctx *gin.Context) string {
+			return "123"
+		}).
+		SetMaxActivePerKey(1).
+		SetShadowMode(true).
+		SetShadowHook(func(ctx *gin.Context, key string, current, maxActive int64) {
+			keys = append(keys, key)
+		})
+	server := gin.Default()
+	server.Use(limit.Build())
+	server.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	// 和 locallimit 一样回调请求的 key，而不是 Redis 里面的 key
+	assert.Equal(t, []string{"123"}, keys)
+}
@@ -0,0 +1,10 @@
+-- 续约，已经释放或者被回收的租约不会重新加回去
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local expireAt = now + tonumber(ARGV[2])
+for _, key in ipairs(KEYS) do
+    if redis.call('ZADD', key, 'XX', 'CH', expireAt, ARGV[1]) > 0 then
+        redis.call('PEXPIRE', key, ARGV[2])
+    end
+end
+return 0
@@ -18,7 +18,9 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/ecodeclub/ekit/bean/option"
 	"github.com/ecodeclub/ginx/internal/crawlerdetect"
 	"github.com/gin-gonic/gin"
 )
@@ -28,8 +30,25 @@ const Bing = crawlerdetect.Bing
 const Google = crawlerdetect.Google
 const Sogou = crawlerdetect.Sogou
 
+// CtxCrawlerKey 通过校验的请求会把爬虫的名字放到 gin.Context 中，
+// 例如 Baidu，后面的 middleware 可以据此区别对待爬虫的流量
+const CtxCrawlerKey = "_crawler"
+
+// Resolver 校验爬虫需要的 DNS 查询，测试的时候可以替换
+type Resolver = crawlerdetect.Resolver
+
+// NewNetResolver 使用 net.DefaultResolver，每一次查询最多 timeout
+func NewNetResolver(timeout time.Duration) Resolver {
+	return crawlerdetect.NewNetResolver(timeout)
+}
+
 type Builder struct {
 	crawlersMap map[string]string
+	// 为空的时候使用默认的策略
+	opts       []option.Option[crawlerdetect.UniversalStrategy]
+	strategies map[string]crawlerdetect.Strategy
+	// 只标记爬虫，不拦截其它的请求
+	annotateOnly bool
 }
 
 func NewBuilder() *Builder {
@@ -80,39 +99,80 @@ func (b *Builder) RemoveUserAgent(userAgents ...string) *Builder {
 	return b
 }
 
+// SetResolver 默认使用带 3 秒超时的 net.DefaultResolver
+func (b *Builder) SetResolver(r Resolver) *Builder {
+	b.opts = append(b.opts, crawlerdetect.WithResolver(r))
+	return b
+}
+
+// SetCache 缓存校验的结果，最多 capacity 条，是爬虫的结果缓存 ttl，不是爬虫的缓存 negativeTTL。
+// 默认所有的 Builder 共享一个 10000 条的缓存，分别缓存 1 小时和 10 分钟，capacity 小于等于 0 表示不缓存
+func (b *Builder) SetCache(capacity int, ttl, negativeTTL time.Duration) *Builder {
+	var c *crawlerdetect.Cache
+	if capacity > 0 {
+		c = crawlerdetect.NewCache(capacity, ttl, negativeTTL)
+	}
+	b.opts = append(b.opts, crawlerdetect.WithCache(c))
+	return b
+}
+
+// SetAnnotateOnly 开启之后不会拦截任何请求，只是给通过校验的爬虫设置 CtxCrawlerKey，
+// 适用于爬虫和普通用户共用的路由，例如配合 loadshed 优先丢弃爬虫的请求
+func (b *Builder) SetAnnotateOnly(enabled bool) *Builder {
+	b.annotateOnly = enabled
+	return b
+}
+
 func (b *Builder) Build() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		userAgent := ctx.GetHeader("User-Agent")
-		ip := ctx.ClientIP()
-		if ip == "" {
-			slog.ErrorContext(ctx, "crawlerdetect", "error", "ip is empty.")
-			ctx.AbortWithStatus(http.StatusForbidden)
-			return
+	b.strategies = make(map[string]crawlerdetect.Strategy, len(b.crawlersMap))
+	for _, crawler := range b.crawlersMap {
+		if _, ok := b.strategies[crawler]; !ok {
+			b.strategies[crawler] = crawlerdetect.NewCrawlerDetector(crawler, b.opts...)
 		}
-		crawlerDetector := b.getCrawlerDetector(userAgent)
-		if crawlerDetector == nil {
-			ctx.AbortWithStatus(http.StatusForbidden)
-			return
-		}
-		pass, err := crawlerDetector.CheckCrawler(ip)
-		if err != nil {
-			slog.ErrorContext(ctx, "crawlerdetect", "error", err.Error())
-			ctx.AbortWithStatus(http.StatusInternalServerError)
+	}
+	return func(ctx *gin.Context) {
+		crawler, code := b.verify(ctx)
+		if crawler != "" {
+			ctx.Set(CtxCrawlerKey, crawler)
+			ctx.Next()
 			return
 		}
-		if !pass {
-			ctx.AbortWithStatus(http.StatusForbidden)
+		if b.annotateOnly {
+			ctx.Next()
 			return
 		}
-		ctx.Next()
+		ctx.AbortWithStatus(code)
+	}
+}
+
+// verify 返回通过校验的爬虫的名字，没有通过的时候返回应该响应的状态码
+func (b *Builder) verify(ctx *gin.Context) (string, int) {
+	userAgent := ctx.GetHeader("User-Agent")
+	ip := ctx.ClientIP()
+	if ip == "" {
+		slog.ErrorContext(ctx, "crawlerdetect", "error", "ip is empty.")
+		return "", http.StatusForbidden
+	}
+	crawler, crawlerDetector := b.getCrawlerDetector(userAgent)
+	if crawlerDetector == nil {
+		return "", http.StatusForbidden
+	}
+	pass, err := crawlerDetector.CheckCrawlerContext(ctx.Request.Context(), ip)
+	if err != nil {
+		slog.ErrorContext(ctx, "crawlerdetect", "error", err.Error())
+		return "", http.StatusInternalServerError
+	}
+	if !pass {
+		return "", http.StatusForbidden
 	}
+	return crawler, http.StatusOK
 }
 
-func (b *Builder) getCrawlerDetector(userAgent string) crawlerdetect.Strategy {
+func (b *Builder) getCrawlerDetector(userAgent string) (string, crawlerdetect.Strategy) {
 	for key, value := range b.crawlersMap {
 		if strings.Contains(userAgent, key) {
-			return crawlerdetect.NewCrawlerDetector(value)
+			return value, b.strategies[value]
 		}
 	}
-	return nil
+	return "", nil
 }
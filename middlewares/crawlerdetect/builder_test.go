@@ -18,9 +18,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
+
+	"github.com/ecodeclub/ginx/internal/crawlerdetect/crawlertest"
 )
 
 func Test_Builder(t *testing.T) {
@@ -224,6 +227,19 @@ func Test_Builder(t *testing.T) {
 			},
 			wantCode: 200,
 		},
+		{
+			name: "伪造的百度",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/test", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)")
+				req.Header.Set("X-Forwarded-For", "66.249.66.1")
+				return req
+			},
+			wantCode: 403,
+		},
 		{
 			name: "搜狗 - Sogou web spider",
 			reqBuilder: func(t *testing.T) *http.Request {
@@ -237,13 +253,28 @@ func Test_Builder(t *testing.T) {
 			},
 			wantCode: 200,
 		},
+		{
+			name: "搜狗 - Sogou web spider 反向解析",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/test", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("User-Agent", "Sogou web spider/4.0(+http://www.sogou.com/docs/help/webmasters.htm#07)")
+				req.Header.Set("X-Forwarded-For", "123.126.113.110")
+				return req
+			},
+			wantCode: 200,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			server := gin.Default()
 			server.TrustedPlatform = "X-Forwarded-For"
-			server.Use(NewBuilder().Build())
+			server.Use(NewBuilder().SetResolver(crawlertest.NewResolver()).SetCache(0, 0, 0).Build())
 			server.GET("/test", func(ctx *gin.Context) {
+				_, ok := ctx.Get(CtxCrawlerKey)
+				require.True(t, ok)
 				ctx.JSON(200, nil)
 			})
 
@@ -257,6 +288,80 @@ func Test_Builder(t *testing.T) {
 	}
 }
 
+func TestBuilder_SetCache(t *testing.T) {
+	r := crawlertest.NewResolver()
+	server := gin.New()
+	server.TrustedPlatform = "X-Forwarded-For"
+	server.Use(NewBuilder().SetResolver(r).SetCache(10, time.Minute, time.Minute).Build())
+	server.GET("/test", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString(CtxCrawlerKey))
+	})
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, "/test", nil)
+		require.NoError(t, err)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+		req.Header.Set("X-Forwarded-For", "66.249.66.1")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, Google, recorder.Body.String())
+	}
+	// 只有第一次需要反向和正向查询
+	require.Equal(t, 2, r.Lookups())
+}
+
+func TestBuilder_SetAnnotateOnly(t *testing.T) {
+	testCases := []struct {
+		name      string
+		userAgent string
+		ip        string
+
+		wantCrawler string
+	}{
+		{
+			name:      "用户",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
+			ip:        "155.206.198.69",
+		},
+		{
+			name:        "百度",
+			userAgent:   "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)",
+			ip:          "111.206.198.69",
+			wantCrawler: Baidu,
+		},
+		{
+			name:      "伪造的百度",
+			userAgent: "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)",
+			ip:        "66.249.66.1",
+		},
+		{
+			name:      "校验出错",
+			userAgent: "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)",
+			ip:        "256.0.0.0",
+		},
+	}
+	server := gin.New()
+	server.TrustedPlatform = "X-Forwarded-For"
+	server.Use(NewBuilder().SetResolver(crawlertest.NewResolver()).
+		SetCache(0, 0, 0).SetAnnotateOnly(true).Build())
+	server.GET("/test", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString(CtxCrawlerKey))
+	})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/test", nil)
+			require.NoError(t, err)
+			req.Header.Set("User-Agent", tc.userAgent)
+			req.Header.Set("X-Forwarded-For", tc.ip)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			// 不拦截任何请求，只有通过校验的爬虫才会被标记
+			require.Equal(t, http.StatusOK, recorder.Code)
+			require.Equal(t, tc.wantCrawler, recorder.Body.String())
+		})
+	}
+}
+
 func TestBuilder_AddUserAgent(t *testing.T) {
 	b := NewBuilder().AddUserAgent(map[string][]string{
 		Baidu: {"test-new-baidu-user-agent"},
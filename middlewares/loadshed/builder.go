@@ -0,0 +1,212 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadshed
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/atomic"
+)
+
+// Builder 按照优先级丢弃请求。
+// 负载用 正在处理的请求数、排队时间 和 CPU 使用率 三个指标衡量，
+// 每个指标除以它的阈值得到压力，取最大的一个。
+// 压力超过某个优先级的比例之后，这个优先级的请求返回 503。
+// 默认 PriorityLow 在压力达到 0.8 的时候开始丢弃，PriorityNormal 是 1，PriorityCritical 永远不丢弃
+type Builder struct {
+	classify Classifier
+	// 各个优先级开始丢弃的压力
+	ratios map[Priority]float64
+
+	// 阈值为 0 的指标不参与计算
+	maxInFlight   int64
+	maxQueueDelay time.Duration
+	maxCPU        float64
+
+	inFlight     *atomic.Int64
+	queueDelayFn func(ctx *gin.Context) time.Duration
+	// 最近的请求排队时间的滑动平均值
+	mu         sync.Mutex
+	queueDelay float64
+	cpu        CPUSampler
+
+	retryAfter time.Duration
+	onShed     func(ctx *gin.Context, priority Priority, pressure float64)
+}
+
+// NewBuilder classify 为 nil 的时候使用 DefaultClassifier()
+func NewBuilder(classify Classifier) *Builder {
+	if classify == nil {
+		classify = DefaultClassifier()
+	}
+	return &Builder{
+		classify: classify,
+		ratios: map[Priority]float64{
+			PriorityLow:      0.8,
+			PriorityNormal:   1,
+			PriorityCritical: math.Inf(1),
+		},
+		inFlight:     atomic.NewInt64(0),
+		queueDelayFn: QueueDelayFromHeader("X-Request-Start"),
+		retryAfter:   time.Second,
+		onShed:       func(ctx *gin.Context, priority Priority, pressure float64) {},
+	}
+}
+
+// SetMaxInFlight 正在处理的请求数的阈值
+func (b *Builder) SetMaxInFlight(maxInFlight int64) *Builder {
+	b.maxInFlight = maxInFlight
+	return b
+}
+
+// SetMaxQueueDelay 排队时间的阈值，排队时间默认从 X-Request-Start 请求头中计算
+func (b *Builder) SetMaxQueueDelay(maxQueueDelay time.Duration) *Builder {
+	b.maxQueueDelay = maxQueueDelay
+	return b
+}
+
+// SetQueueDelayFunc 设置怎么计算请求的排队时间，例如从网关设置的请求头中计算
+func (b *Builder) SetQueueDelayFunc(fn func(ctx *gin.Context) time.Duration) *Builder {
+	b.queueDelayFn = fn
+	return b
+}
+
+// SetMaxCPU CPU 使用率的阈值，范围是 (0, 1]。
+// 默认使用进程的 CPU 时间除以 GOMAXPROCS 个核的时间估算使用率，每 500 毫秒采样一次
+func (b *Builder) SetMaxCPU(maxCPU float64) *Builder {
+	b.maxCPU = maxCPU
+	if b.cpu == nil {
+		b.cpu = newProcessCPU(500 * time.Millisecond)
+	}
+	return b
+}
+
+// SetCPUSampler 设置怎么获取 CPU 使用率，例如使用容器的 cgroup 数据
+func (b *Builder) SetCPUSampler(sampler CPUSampler) *Builder {
+	b.cpu = sampler
+	return b
+}
+
+// SetShedRatio 压力达到 ratio 之后丢弃 priority 的请求
+func (b *Builder) SetShedRatio(priority Priority, ratio float64) *Builder {
+	b.ratios[priority] = ratio
+	return b
+}
+
+// SetRetryAfter 被丢弃的请求的 Retry-After 响应头，默认 1 秒
+func (b *Builder) SetRetryAfter(retryAfter time.Duration) *Builder {
+	b.retryAfter = retryAfter
+	return b
+}
+
+// SetShedHook 请求被丢弃的时候回调，例如打日志或者记录监控
+func (b *Builder) SetShedHook(fn func(ctx *gin.Context, priority Priority, pressure float64)) *Builder {
+	b.onShed = fn
+	return b
+}
+
+func (b *Builder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		priority := b.classify(ctx)
+		current := b.inFlight.Add(1)
+		defer b.inFlight.Sub(1)
+		pressure := b.pressure(ctx, current-1)
+		ratio, ok := b.ratios[priority]
+		if !ok {
+			ratio = 1
+		}
+		if pressure >= ratio {
+			b.onShed(ctx, priority, pressure)
+			ctx.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(b.retryAfter.Seconds())), 10))
+			ctx.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// pressure 计算当前的压力，inFlight 不包括这个请求
+func (b *Builder) pressure(ctx *gin.Context, inFlight int64) float64 {
+	var res float64
+	if b.maxInFlight > 0 {
+		res = math.Max(res, float64(inFlight)/float64(b.maxInFlight))
+	}
+	if b.maxQueueDelay > 0 {
+		res = math.Max(res, b.observeQueueDelay(ctx)/float64(b.maxQueueDelay))
+	}
+	if b.maxCPU > 0 && b.cpu != nil {
+		res = math.Max(res, b.cpu.Usage()/b.maxCPU)
+	}
+	return res
+}
+
+// observeQueueDelay 单个请求的排队时间波动很大，所以使用滑动平均值。
+// 没有排队的请求也参与计算，这样排队恢复之后平均值会降下来
+func (b *Builder) observeQueueDelay(ctx *gin.Context) float64 {
+	delay := b.queueDelayFn(ctx)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queueDelay += (float64(delay) - b.queueDelay) / 8
+	return b.queueDelay
+}
+
+// QueueDelayFromHeader 从网关设置的请求头中计算排队时间，请求头是请求到达网关的时间。
+// 支持 nginx 的 t=1695571200.123 格式的秒，以及毫秒或者微秒的时间戳，
+// 没有请求头或者格式不对的时候返回 0
+func QueueDelayFromHeader(name string) func(ctx *gin.Context) time.Duration {
+	return func(ctx *gin.Context) time.Duration {
+		start, ok := parseRequestStart(ctx.GetHeader(name))
+		if !ok {
+			return 0
+		}
+		delay := time.Since(start)
+		if delay < 0 {
+			return 0
+		}
+		return delay
+	}
+}
+
+func parseRequestStart(val string) (time.Time, bool) {
+	val = strings.TrimPrefix(val, "t=")
+	if val == "" {
+		return time.Time{}, false
+	}
+	if strings.Contains(val, ".") {
+		sec, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.UnixMicro(int64(sec * 1e6)), true
+	}
+	ts, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case ts > 1e15:
+		return time.UnixMicro(ts), true
+	case ts > 1e12:
+		return time.UnixMilli(ts), true
+	default:
+		return time.Unix(ts, 0), true
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadshed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ecodeclub/ginx/internal/crawlerdetect/crawlertest"
+	"github.com/ecodeclub/ginx/middlewares/crawlerdetect"
+)
+
+type fakeCPU float64
+
+func (f *fakeCPU) Usage() float64 {
+	return float64(*f)
+}
+
+func TestBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name     string
+		inFlight int64
+		cpu      float64
+		delay    time.Duration
+
+		wantCodes map[string]int
+	}{
+		{
+			name: "负载低",
+			wantCodes: map[string]int{
+				"/health": http.StatusOK, "/users": http.StatusOK, "/crawler": http.StatusOK,
+			},
+		},
+		{
+			name:     "正在处理的请求多，先丢弃爬虫",
+			inFlight: 8,
+			wantCodes: map[string]int{
+				"/health": http.StatusOK, "/users": http.StatusOK, "/crawler": http.StatusServiceUnavailable,
+			},
+		},
+		{
+			name: "CPU 高",
+			cpu:  0.9,
+			wantCodes: map[string]int{
+				"/health": http.StatusOK, "/users": http.StatusServiceUnavailable, "/crawler": http.StatusServiceUnavailable,
+			},
+		},
+		{
+			name:  "排队时间长",
+			delay: 200 * time.Millisecond,
+			wantCodes: map[string]int{
+				"/health": http.StatusOK, "/users": http.StatusServiceUnavailable, "/crawler": http.StatusServiceUnavailable,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := fakeCPU(tc.cpu)
+			var shed []Priority
+			b := NewBuilder(DefaultClassifier("/health")).
+				SetMaxInFlight(10).
+				SetMaxCPU(0.9).
+				SetCPUSampler(&cpu).
+				SetMaxQueueDelay(100 * time.Millisecond).
+				SetQueueDelayFunc(func(ctx *gin.Context) time.Duration {
+					return tc.delay
+				}).
+				SetRetryAfter(1500 * time.Millisecond).
+				SetShedHook(func(ctx *gin.Context, priority Priority, pressure float64) {
+					shed = append(shed, priority)
+				})
+			// 平均值需要几个请求才能上去
+			b.queueDelay = float64(tc.delay)
+			b.inFlight.Store(tc.inFlight)
+
+			server := gin.New()
+			server.Use(func(ctx *gin.Context) {
+				if ctx.Request.URL.Path == "/crawler" {
+					ctx.Set(crawlerdetect.CtxCrawlerKey, crawlerdetect.Baidu)
+				}
+			}, b.Build())
+			for path := range tc.wantCodes {
+				server.GET(path, func(ctx *gin.Context) {
+					ctx.Status(http.StatusOK)
+				})
+			}
+			for path, code := range tc.wantCodes {
+				req, err := http.NewRequest(http.MethodGet, path, nil)
+				require.NoError(t, err)
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, req)
+				assert.Equal(t, code, recorder.Code, path)
+				if code == http.StatusServiceUnavailable {
+					assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+				}
+			}
+			assert.NotContains(t, shed, PriorityCritical)
+			assert.Equal(t, tc.inFlight, b.inFlight.Load())
+		})
+	}
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	classify := DefaultClassifier("/pay/*")
+	testCases := []struct {
+		name    string
+		path    string
+		crawler bool
+		want    Priority
+	}{
+		{name: "默认的探针", path: "/healthz", want: PriorityCritical},
+		{name: "前缀匹配", path: "/pay/:id", want: PriorityCritical},
+		{name: "探针不会被当成爬虫", path: "/ready", crawler: true, want: PriorityCritical},
+		{name: "爬虫", path: "/articles/:id", crawler: true, want: PriorityLow},
+		{name: "普通请求", path: "/articles/:id", want: PriorityNormal},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Priority
+			server := gin.New()
+			server.GET(tc.path, func(ctx *gin.Context) {
+				if tc.crawler {
+					ctx.Set(crawlerdetect.CtxCrawlerKey, crawlerdetect.Baidu)
+				}
+				got = classify(ctx)
+			})
+			url := strings.ReplaceAll(tc.path, ":id", "1")
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			require.NoError(t, err)
+			server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestBuilder_MixedTraffic(t *testing.T) {
+	b := NewBuilder(nil).SetMaxInFlight(10)
+	b.inFlight.Store(8)
+	server := gin.New()
+	server.TrustedPlatform = "X-Forwarded-For"
+	// 爬虫和普通用户共用一个路由
+	server.Use(crawlerdetect.NewBuilder().SetResolver(crawlertest.NewResolver()).
+		SetCache(0, 0, 0).SetAnnotateOnly(true).Build(), b.Build())
+	server.GET("/articles/:id", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	testCases := []struct {
+		name      string
+		userAgent string
+		ip        string
+		wantCode  int
+	}{
+		{
+			name:      "用户",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
+			ip:        "155.206.198.69",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "百度",
+			userAgent: "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)",
+			ip:        "111.206.198.69",
+			wantCode:  http.StatusServiceUnavailable,
+		},
+		{
+			// 没有通过校验的当成普通用户
+			name:      "伪造的百度",
+			userAgent: "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)",
+			ip:        "66.249.66.1",
+			wantCode:  http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/articles/1", nil)
+			require.NoError(t, err)
+			req.Header.Set("User-Agent", tc.userAgent)
+			req.Header.Set("X-Forwarded-For", tc.ip)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestBuilder_QueueDelayDecay(t *testing.T) {
+	b := NewBuilder(nil).
+		SetMaxQueueDelay(100 * time.Millisecond).
+		SetQueueDelayFunc(func(ctx *gin.Context) time.Duration {
+			return 0
+		})
+	// 之前排队很严重
+	b.queueDelay = float64(200 * time.Millisecond)
+	server := gin.New()
+	server.Use(b.Build())
+	server.GET("/users", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	serve := func() int {
+		req, err := http.NewRequest(http.MethodGet, "/users", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	// 排队恢复之后平均值会降下来
+	for i := 0; i < 10; i++ {
+		serve()
+	}
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Less(t, b.queueDelay, float64(50*time.Millisecond))
+}
+
+func TestQueueDelayFromHeader(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name   string
+		header string
+		// 排队时间至少是多少
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{name: "没有请求头"},
+		{name: "格式不对", header: "abc"},
+		{name: "未来的时间", header: strconv.FormatInt(now.Add(time.Hour).UnixMilli(), 10)},
+		{
+			name:    "nginx",
+			header:  "t=" + strconv.FormatFloat(float64(now.Add(-time.Second).UnixMicro())/1e6, 'f', 3, 64),
+			wantMin: 990 * time.Millisecond, wantMax: 2 * time.Second,
+		},
+		{
+			name:    "毫秒",
+			header:  strconv.FormatInt(now.Add(-time.Second).UnixMilli(), 10),
+			wantMin: time.Second, wantMax: 2 * time.Second,
+		},
+		{
+			name:    "微秒",
+			header:  strconv.FormatInt(now.Add(-time.Second).UnixMicro(), 10),
+			wantMin: time.Second, wantMax: 2 * time.Second,
+		},
+	}
+	fn := QueueDelayFromHeader("X-Request-Start")
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Request-Start", tc.header)
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = req
+			delay := fn(ctx)
+			assert.GreaterOrEqual(t, delay, tc.wantMin)
+			assert.LessOrEqual(t, delay, tc.wantMax)
+		})
+	}
+}
+
+func TestProcessCPU(t *testing.T) {
+	now := time.Unix(100, 0)
+	var used time.Duration
+	cpu := newProcessCPUWith(time.Second, func() (time.Duration, bool) {
+		return used, true
+	}, func() time.Time {
+		return now
+	})
+	procs := time.Duration(runtime.GOMAXPROCS(0))
+
+	// 没有到采样的间隔
+	used = procs * time.Second
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, float64(0), cpu.Usage())
+
+	now = now.Add(1500 * time.Millisecond)
+	assert.InDelta(t, 0.5, cpu.Usage(), 1e-9)
+
+	// 没有消耗 CPU 的时候降下来
+	now = now.Add(time.Second)
+	assert.Equal(t, float64(0), cpu.Usage())
+
+	// 不会超过 1
+	used += 2 * procs * time.Second
+	now = now.Add(time.Second)
+	assert.Equal(t, float64(1), cpu.Usage())
+}
+
+func TestProcessCPU_Busy(t *testing.T) {
+	if _, ok := processCPUTime(); !ok {
+		t.Skip("不支持获取进程的 CPU 时间")
+	}
+	cpu := newProcessCPU(10 * time.Millisecond)
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+	usage := cpu.Usage()
+	assert.Greater(t, usage, float64(0))
+	assert.LessOrEqual(t, usage, float64(1))
+}
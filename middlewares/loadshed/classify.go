@@ -0,0 +1,81 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadshed
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ecodeclub/ginx/middlewares/crawlerdetect"
+)
+
+// Priority 请求的优先级，负载高的时候先丢弃优先级低的请求
+type Priority int
+
+const (
+	// PriorityLow 例如爬虫，最先被丢弃
+	PriorityLow Priority = iota
+	// PriorityNormal 普通的请求
+	PriorityNormal
+	// PriorityCritical 例如健康检查和支付，永远不会被丢弃
+	PriorityCritical
+)
+
+// Classifier 判断请求的优先级
+type Classifier func(ctx *gin.Context) Priority
+
+// DefaultCriticalPaths 常见的健康检查和就绪检查的路由，DefaultClassifier 永远不会丢弃这些请求，
+// 不然压力大的时候探针失败，实例会被摘掉或者重启，剩下的实例压力更大
+var DefaultCriticalPaths = []string{
+	"/health", "/healthz", "/livez", "/ready", "/readyz", "/ping",
+}
+
+// DefaultClassifier DefaultCriticalPaths 和 critical 里面的路由是 PriorityCritical，
+// 通过了 crawlerdetect 校验的爬虫是 PriorityLow，其它的是 PriorityNormal.
+// 路由是注册时使用的模式, 例如 /pay/:id, 以 * 结尾表示前缀匹配.
+// 探针使用了别的路由的时候，一定要放到 critical 里面，否则压力大的时候会被丢弃.
+// 爬虫需要 crawlerdetect 的 middleware 放在前面，
+// 爬虫和普通用户共用的路由需要开启 crawlerdetect 的 SetAnnotateOnly，否则普通用户会被拦截
+func DefaultClassifier(critical ...string) Classifier {
+	patterns := make([]string, 0, len(DefaultCriticalPaths)+len(critical))
+	patterns = append(patterns, DefaultCriticalPaths...)
+	patterns = append(patterns, critical...)
+	return func(ctx *gin.Context) Priority {
+		path := ctx.FullPath()
+		for _, pattern := range patterns {
+			if matchPath(pattern, path) {
+				return PriorityCritical
+			}
+		}
+		if IsCrawler(ctx) {
+			return PriorityLow
+		}
+		return PriorityNormal
+	}
+}
+
+// IsCrawler 请求是不是通过了 crawlerdetect 校验的爬虫
+func IsCrawler(ctx *gin.Context) bool {
+	_, ok := ctx.Get(crawlerdetect.CtxCrawlerKey)
+	return ok
+}
+
+func matchPath(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return pattern == path
+}
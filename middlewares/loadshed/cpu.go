@@ -0,0 +1,74 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadshed
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// CPUSampler 返回进程的 CPU 使用率，范围是 [0, 1]
+type CPUSampler interface {
+	Usage() float64
+}
+
+// processCPU 用进程消耗的 CPU 时间除以这段时间内 GOMAXPROCS 个核的总时间计算使用率。
+// 每次调用的时候如果离上一次采样超过了 interval 就重新采样，不需要后台的 goroutine
+type processCPU struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// 进程启动以来消耗的 CPU 时间，包括用户态和内核态，不支持的平台返回 false
+	cpuTime func() (time.Duration, bool)
+	// 上一次采样的时候进程的 CPU 时间和采样的时间
+	used    time.Duration
+	last    time.Time
+	usage   float64
+	nowFunc func() time.Time
+}
+
+func newProcessCPU(interval time.Duration) *processCPU {
+	return newProcessCPUWith(interval, processCPUTime, time.Now)
+}
+
+func newProcessCPUWith(interval time.Duration,
+	cpuTime func() (time.Duration, bool), nowFunc func() time.Time) *processCPU {
+	res := &processCPU{
+		interval: interval,
+		cpuTime:  cpuTime,
+		nowFunc:  nowFunc,
+	}
+	res.used, _ = res.cpuTime()
+	res.last = res.nowFunc()
+	return res
+}
+
+func (c *processCPU) Usage() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.nowFunc()
+	elapsed := now.Sub(c.last)
+	if elapsed < c.interval {
+		return c.usage
+	}
+	used, ok := c.cpuTime()
+	if !ok {
+		return 0
+	}
+	usage := float64(used-c.used) / (float64(elapsed) * float64(runtime.GOMAXPROCS(0)))
+	c.usage = min(max(usage, 0), 1)
+	c.used, c.last = used, now
+	return c.usage
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ecodeclub/ginx"
+	"github.com/ecodeclub/ginx/internal/quota"
+)
+
+// AdminHandler 管理配额的接口, 用于查询, 重置以及充值.
+// 这些接口没有任何权限校验, 必须注册到只有管理员才能访问的路由上
+type AdminHandler struct {
+	quota quota.Quota
+}
+
+func NewAdminHandler(q quota.Quota) *AdminHandler {
+	return &AdminHandler{quota: q}
+}
+
+// RegisterRoutes 注册
+// GET /quota/:key 查询
+// POST /quota/:key/reset 重置
+// POST /quota/:key/top-up 充值
+func (h *AdminHandler) RegisterRoutes(server gin.IRoutes) {
+	server.GET("/quota/:key", ginx.W(h.Get))
+	server.POST("/quota/:key/reset", ginx.W(h.Reset))
+	server.POST("/quota/:key/top-up", ginx.B[TopUpReq](h.TopUp))
+}
+
+func (h *AdminHandler) Get(ctx *ginx.Context) (ginx.Result, error) {
+	u, err := h.quota.Get(ctx, ctx.Param("key").StringOrDefault(""))
+	if err != nil {
+		return ginx.Result{Code: http.StatusInternalServerError, Msg: "系统错误"}, err
+	}
+	return ginx.Result{Data: newUsage(u)}, nil
+}
+
+func (h *AdminHandler) Reset(ctx *ginx.Context) (ginx.Result, error) {
+	err := h.quota.Reset(ctx, ctx.Param("key").StringOrDefault(""))
+	if err != nil {
+		return ginx.Result{Code: http.StatusInternalServerError, Msg: "系统错误"}, err
+	}
+	return ginx.Result{Msg: "OK"}, nil
+}
+
+type TopUpReq struct {
+	Amount int64 `json:"amount"`
+}
+
+func (h *AdminHandler) TopUp(ctx *ginx.Context, req TopUpReq) (ginx.Result, error) {
+	if req.Amount <= 0 {
+		return ginx.Result{Code: http.StatusBadRequest, Msg: "充值数量必须大于 0"}, nil
+	}
+	err := h.quota.TopUp(ctx, ctx.Param("key").StringOrDefault(""), req.Amount)
+	if err != nil {
+		return ginx.Result{Code: http.StatusInternalServerError, Msg: "系统错误"}, err
+	}
+	return ginx.Result{Msg: "OK"}, nil
+}
+
+// Usage 配额的使用情况
+type Usage struct {
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+	// ResetAt 周期结束的时间, 毫秒
+	ResetAt int64 `json:"resetAt"`
+}
+
+func newUsage(u quota.Usage) Usage {
+	return Usage{
+		Limit:     u.Limit,
+		Used:      u.Used,
+		Remaining: u.Remaining(),
+		ResetAt:   u.ResetAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ecodeclub/ginx/internal/quota"
+	quotamocks "github.com/ecodeclub/ginx/internal/quota/mocks"
+)
+
+func TestAdminHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   func(ctrl *gomock.Controller) quota.Quota
+		method string
+		path   string
+		body   string
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "查询",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().Get(gomock.Any(), "abc").
+					Return(quota.Usage{Limit: 10, Used: 3, ResetAt: time.UnixMilli(1695571200000)}, nil)
+				return q
+			},
+			method:   http.MethodGet,
+			path:     "/admin/quota/abc",
+			wantCode: http.StatusOK,
+			wantBody: `{"code":0,"msg":"","data":{"limit":10,"used":3,"remaining":7,"resetAt":1695571200000}}`,
+		},
+		{
+			name: "查询失败",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().Get(gomock.Any(), "abc").
+					Return(quota.Usage{}, errors.New("模拟系统错误"))
+				return q
+			},
+			method:   http.MethodGet,
+			path:     "/admin/quota/abc",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"code":500,"msg":"系统错误","data":null}`,
+		},
+		{
+			name: "重置",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().Reset(gomock.Any(), "abc").Return(nil)
+				return q
+			},
+			method:   http.MethodPost,
+			path:     "/admin/quota/abc/reset",
+			wantCode: http.StatusOK,
+			wantBody: `{"code":0,"msg":"OK","data":null}`,
+		},
+		{
+			name: "充值",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().TopUp(gomock.Any(), "abc", int64(100)).Return(nil)
+				return q
+			},
+			method:   http.MethodPost,
+			path:     "/admin/quota/abc/top-up",
+			body:     `{"amount":100}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":0,"msg":"OK","data":null}`,
+		},
+		{
+			name: "充值数量不合法",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				return quotamocks.NewMockQuota(ctrl)
+			},
+			method:   http.MethodPost,
+			path:     "/admin/quota/abc/top-up",
+			body:     `{"amount":-1}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":400,"msg":"充值数量必须大于 0","data":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.New()
+			NewAdminHandler(tt.mock(ctrl)).RegisterRoutes(server.Group("/admin"))
+
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.JSONEq(t, tt.wantBody, recorder.Body.String())
+		})
+	}
+}
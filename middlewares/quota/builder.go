@@ -0,0 +1,95 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ecodeclub/ginx"
+	"github.com/ecodeclub/ginx/internal/quota"
+)
+
+// Builder 长周期的配额, 例如每个月 10000 次调用.
+// 和限流不同, 配额一般是套餐的一部分, 用完之后要等到下一个周期或者充值
+type Builder struct {
+	quota    quota.Quota
+	genKeyFn func(ctx *gin.Context) string
+	logFn    func(msg any, args ...any)
+	nowFunc  func() time.Time
+}
+
+// NewBuilder
+// genKeyFn: 默认按照 IP, 一般需要设置成 API key 或者用户.
+// logFn: 默认使用 log.Println().
+func NewBuilder(q quota.Quota) *Builder {
+	return &Builder{
+		quota: q,
+		genKeyFn: func(ctx *gin.Context) string {
+			var b strings.Builder
+			b.WriteString("ip-quota")
+			b.WriteString(":")
+			b.WriteString(ctx.ClientIP())
+			return b.String()
+		},
+		logFn: func(msg any, args ...any) {
+			v := make([]any, 0, len(args)+1)
+			v = append(v, msg)
+			v = append(v, args...)
+			log.Println(v...)
+		},
+		nowFunc: time.Now,
+	}
+}
+
+func (b *Builder) SetKeyGenFunc(fn func(*gin.Context) string) *Builder {
+	b.genKeyFn = fn
+	return b
+}
+
+func (b *Builder) SetLogFunc(fn func(msg any, args ...any)) *Builder {
+	b.logFn = fn
+	return b
+}
+
+func (b *Builder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		u, err := b.quota.Consume(ctx, b.genKeyFn(ctx), 1)
+		if err != nil {
+			b.logFn(err)
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// 周期结束的时间, 单位是秒, 向上取整
+		reset := strconv.FormatInt(int64((u.ResetAt.Sub(b.nowFunc())+time.Second-1)/time.Second), 10)
+		ctx.Header("X-Quota-Limit", strconv.FormatInt(u.Limit, 10))
+		ctx.Header("X-Quota-Remaining", strconv.FormatInt(u.Remaining(), 10))
+		ctx.Header("X-Quota-Reset", reset)
+		if u.Exceeded {
+			ctx.Header("Retry-After", reset)
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, ginx.Result{
+				Code: http.StatusTooManyRequests,
+				Msg:  "配额已用完",
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ecodeclub/ginx/internal/quota"
+	quotamocks "github.com/ecodeclub/ginx/internal/quota/mocks"
+)
+
+func TestBuilder_Build(t *testing.T) {
+	now := time.UnixMilli(1695571200000)
+	resetAt := now.Add(90 * time.Minute)
+	tests := []struct {
+		name string
+		mock func(ctrl *gomock.Controller) quota.Quota
+
+		wantCode   int
+		wantHeader map[string]string
+		wantBody   string
+	}{
+		{
+			name: "配额足够",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().Consume(gomock.Any(), "api-key:abc", int64(1)).
+					Return(quota.Usage{Limit: 10, Used: 3, ResetAt: resetAt}, nil)
+				return q
+			},
+			wantCode: http.StatusOK,
+			wantHeader: map[string]string{
+				"X-Quota-Limit":     "10",
+				"X-Quota-Remaining": "7",
+				"X-Quota-Reset":     "5400",
+				"Retry-After":       "",
+			},
+		},
+		{
+			name: "配额用完",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().Consume(gomock.Any(), "api-key:abc", int64(1)).
+					Return(quota.Usage{Exceeded: true, Limit: 10, Used: 10, ResetAt: resetAt}, nil)
+				return q
+			},
+			wantCode: http.StatusTooManyRequests,
+			wantHeader: map[string]string{
+				"X-Quota-Limit":     "10",
+				"X-Quota-Remaining": "0",
+				"X-Quota-Reset":     "5400",
+				"Retry-After":       "5400",
+			},
+			wantBody: `{"code":429,"msg":"配额已用完","data":null}`,
+		},
+		{
+			name: "系统错误",
+			mock: func(ctrl *gomock.Controller) quota.Quota {
+				q := quotamocks.NewMockQuota(ctrl)
+				q.EXPECT().Consume(gomock.Any(), "api-key:abc", int64(1)).
+					Return(quota.Usage{}, errors.New("模拟系统错误"))
+				return q
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			b := NewBuilder(tt.mock(ctrl)).
+				SetKeyGenFunc(func(ctx *gin.Context) string {
+					return "api-key:" + ctx.GetHeader("X-Api-Key")
+				}).
+				SetLogFunc(func(msg any, args ...any) {})
+			b.nowFunc = func() time.Time {
+				return now
+			}
+			server := gin.New()
+			server.Use(b.Build())
+			server.GET("/quota", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			req, err := http.NewRequest(http.MethodGet, "/quota", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Api-Key", "abc")
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			for k, v := range tt.wantHeader {
+				assert.Equal(t, v, recorder.Header().Get(k), k)
+			}
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, recorder.Body.String())
+			}
+		})
+	}
+}
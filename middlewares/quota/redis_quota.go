@@ -0,0 +1,54 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ecodeclub/ginx/internal/quota"
+)
+
+// Period 配额的周期, 按照日历对齐
+type Period = quota.Period
+
+const (
+	// Daily 每天零点重置
+	Daily = quota.Daily
+	// Monthly 每个月一号零点重置
+	Monthly = quota.Monthly
+)
+
+// NewRedisQuota 创建一个基于 redis 的配额.
+// period: 周期, 按天或者按月
+// limit: 每个周期允许的调用次数
+// 示例: 每个 API key 每个月 10000 次调用
+// NewRedisQuota(cmd, Monthly, 10000, WithLocation(loc))
+func NewRedisQuota(cmd redis.Cmdable, period Period, limit int64,
+	opts ...option.Option[quota.RedisQuota]) quota.Quota {
+	return quota.NewRedisQuota(cmd, period, limit, opts...)
+}
+
+// WithLocation 设置按照哪个时区的日历对齐周期, 默认是 UTC
+func WithLocation(loc *time.Location) option.Option[quota.RedisQuota] {
+	return quota.WithLocation(loc)
+}
+
+// WithPrefix 设置 redis key 的前缀, 默认是 quota
+func WithPrefix(prefix string) option.Option[quota.RedisQuota] {
+	return quota.WithPrefix(prefix)
+}
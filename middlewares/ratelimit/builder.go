@@ -15,26 +15,62 @@
 package ratelimit
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/atomic"
 
+	"github.com/ecodeclub/ginx"
+	"github.com/ecodeclub/ginx/internal/breaker"
 	"github.com/ecodeclub/ginx/internal/ratelimit"
 )
 
+// FailPolicy 限流器出错的时候怎么处理请求
+type FailPolicy = ratelimit.FailPolicy
+
+const (
+	// FailClosed 返回 500，默认策略
+	FailClosed = ratelimit.FailClosed
+	// FailOpen 直接放行
+	FailOpen = ratelimit.FailOpen
+	// FailFallback 降级为本地限流，需要调用 SetFallbackLimiter
+	FailFallback = ratelimit.FailFallback
+)
+
 type Builder struct {
 	limiter  ratelimit.Limiter
 	genKeyFn func(ctx *gin.Context) string
 	logFn    func(msg any, args ...any)
+	// 为 nil 的时候每个请求消耗一个配额
+	costFn CostFunc
+
+	policy   FailPolicy
+	fallback ratelimit.Limiter
+	// 限流器连续出错之后就不再访问它，避免压垮已经出问题的 Redis
+	breaker *breaker.Breaker
+	// 为 nil 的时候不封禁
+	penalty *PenaltyBox
+
+	// 影子模式下只记录，不限流
+	shadow   *atomic.Bool
+	shadowFn ShadowFunc
 }
 
+// ShadowFunc 影子模式下，本来会被限流的请求会回调这个方法.
+// rule 是命中的规则的名字，Builder 里面为空
+type ShadowFunc func(ctx *gin.Context, rule string, key string, d ratelimit.Decision)
+
 // NewBuilder
 // genKeyFn: 默认使用 IP 限流.
 // logFn: 默认使用 log.Println().
 func NewBuilder(limiter ratelimit.Limiter) *Builder {
-	return &Builder{
+	b := &Builder{
 		limiter: limiter,
 		genKeyFn: func(ctx *gin.Context) string {
 			var b strings.Builder
@@ -49,7 +85,13 @@ func NewBuilder(limiter ratelimit.Limiter) *Builder {
 			v = append(v, args...)
 			log.Println(v...)
 		},
+		breaker: breaker.NewBreaker(),
+		shadow:  atomic.NewBool(false),
+	}
+	b.shadowFn = func(ctx *gin.Context, rule string, key string, d ratelimit.Decision) {
+		b.logFn("影子模式，请求本来会被限流", key)
 	}
+	return b
 }
 
 func (b *Builder) SetKeyGenFunc(fn func(*gin.Context) string) *Builder {
@@ -62,22 +104,234 @@ func (b *Builder) SetLogFunc(fn func(msg any, args ...any)) *Builder {
 	return b
 }
 
+// SetCostFunc 设置每个请求消耗的配额，
+// 需要限流器实现了 ratelimit.CostLimiter，否则每个请求还是只消耗一个配额
+func (b *Builder) SetCostFunc(fn CostFunc) *Builder {
+	b.costFn = fn
+	return b
+}
+
+// SetFailPolicy 设置限流器出错时候的策略
+func (b *Builder) SetFailPolicy(policy FailPolicy) *Builder {
+	b.policy = policy
+	return b
+}
+
+// SetFallbackLimiter 设置降级用的限流器，同时把策略设置为 FailFallback。
+// 一般是本地限流器，阈值设置为全局阈值除以实例数
+func (b *Builder) SetFallbackLimiter(limiter ratelimit.Limiter) *Builder {
+	b.fallback = limiter
+	b.policy = FailFallback
+	return b
+}
+
+// SetCircuitBreaker 限流器连续出错 threshold 次之后熔断，
+// 熔断期间直接按照 FailPolicy 处理，cooldown 之后尝试恢复
+func (b *Builder) SetCircuitBreaker(threshold int, cooldown time.Duration) *Builder {
+	b.breaker = breaker.NewBreaker(breaker.WithThreshold(threshold), breaker.WithCooldown(cooldown))
+	return b
+}
+
+// SetPenaltyBox 频繁触发限流的 key 会被封禁，封禁期间的请求直接拒绝，不会访问限流器。
+// 封禁和限流器共用熔断器和 FailPolicy：查询封禁出错的时候，FailClosed 直接返回 500，其它策略继续限流
+func (b *Builder) SetPenaltyBox(penalty *PenaltyBox) *Builder {
+	b.penalty = penalty
+	return b
+}
+
+// SetShadowMode 开启或者关闭影子模式，可以在运行期间调用。
+// 影子模式下照常计算限流结果，但是所有的请求都会放行，本来会被限流的请求交给 ShadowFunc 处理，
+// 适合在上线新的阈值之前观察会影响到哪些请求
+func (b *Builder) SetShadowMode(enabled bool) *Builder {
+	b.shadow.Store(enabled)
+	return b
+}
+
+// SetShadowHook 默认使用 logFn 记录下来
+func (b *Builder) SetShadowHook(fn ShadowFunc) *Builder {
+	b.shadowFn = fn
+	return b
+}
+
 func (b *Builder) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		limited, err := b.limit(ctx)
+		key := b.genKeyFn(ctx)
+		if b.shadow.Load() {
+			b.dryRun(ctx, key)
+			return
+		}
+		if b.penalty != nil {
+			left, err := b.banned(ctx, key)
+			if err != nil {
+				b.logFn("查询封禁失败", err)
+				// 和限流器出错一样按照 FailPolicy 处理，其余的策略交给后面的限流器
+				if requestDone(ctx, err) || b.policy == FailClosed {
+					ctx.AbortWithStatus(http.StatusInternalServerError)
+					return
+				}
+			}
+			if left > 0 {
+				writeHeaders(ctx, ratelimit.Decision{Limited: true, RetryAfter: left})
+				abortLimited(ctx)
+				return
+			}
+		}
+		d, err := b.limit(ctx, key)
 		if err != nil {
 			b.logFn(err)
 			ctx.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-		if limited {
-			ctx.AbortWithStatus(http.StatusTooManyRequests)
+		if d.Limited && b.penalty != nil {
+			ban, err := b.violate(ctx, key)
+			if err != nil {
+				b.logFn("记录限流失败", err)
+			}
+			if ban > d.RetryAfter {
+				d.RetryAfter = ban
+			}
+		}
+		writeHeaders(ctx, d)
+		if d.Limited {
+			abortLimited(ctx)
 			return
 		}
 		ctx.Next()
 	}
 }
 
-func (b *Builder) limit(ctx *gin.Context) (bool, error) {
-	return b.limiter.Limit(ctx, b.genKeyFn(ctx))
+// banned 本地缓存命中的时候不访问 Redis，也不经过熔断器；
+// 否则和限流器共用熔断器，熔断期间不查询封禁
+func (b *Builder) banned(ctx *gin.Context, key string) (time.Duration, error) {
+	if left, ok := b.penalty.Cached(key); ok {
+		return left, nil
+	}
+	if !b.breaker.Allow() {
+		return 0, breaker.ErrOpen
+	}
+	left, err := b.penalty.Banned(ctx.Request.Context(), key)
+	b.record(ctx, err)
+	return left, err
+}
+
+// violate 请求已经被限流了，出错只会少记录一次，熔断期间直接跳过
+func (b *Builder) violate(ctx *gin.Context, key string) (time.Duration, error) {
+	if !b.breaker.Allow() {
+		return 0, nil
+	}
+	ban, err := b.penalty.Violate(ctx.Request.Context(), key)
+	b.record(ctx, err)
+	return ban, err
+}
+
+func (b *Builder) record(ctx *gin.Context, err error) {
+	if err == nil || requestDone(ctx, err) {
+		b.breaker.Success()
+	} else {
+		b.breaker.Failure()
+	}
+}
+
+// dryRun 影子模式不会封禁，也不会设置响应头，对请求没有任何影响
+func (b *Builder) dryRun(ctx *gin.Context, key string) {
+	d, err := b.limit(ctx, key)
+	if err != nil {
+		b.logFn(err)
+	} else if d.Limited {
+		b.shadowFn(ctx, "", key, d)
+	}
+	ctx.Next()
+}
+
+// limit 如果限流器能够提供更多的信息，就使用 Decide
+func (b *Builder) limit(ctx *gin.Context, key string) (ratelimit.Decision, error) {
+	cost := int64(1)
+	if b.costFn != nil {
+		cost = b.costFn(ctx)
+		if cost <= 0 {
+			return ratelimit.Decision{}, nil
+		}
+	}
+	return failover{
+		policy:   b.policy,
+		fallback: b.fallback,
+		breaker:  b.breaker,
+		logFn:    b.logFn,
+	}.decide(ctx, b.limiter, key, cost)
+}
+
+// failover 限流器出错的时候按照 policy 处理，Builder 和 RuleBuilder 共用
+type failover struct {
+	policy   FailPolicy
+	fallback ratelimit.Limiter
+	breaker  *breaker.Breaker
+	logFn    func(msg any, args ...any)
+}
+
+func (f failover) decide(ctx *gin.Context, limiter ratelimit.Limiter, key string, cost int64) (ratelimit.Decision, error) {
+	var (
+		d   ratelimit.Decision
+		err error
+	)
+	if f.breaker.Allow() {
+		d, err = decide(ctx, limiter, key, cost)
+		if err == nil || requestDone(ctx, err) {
+			f.breaker.Success()
+		} else {
+			f.breaker.Failure()
+		}
+	} else {
+		err = breaker.ErrOpen
+	}
+	if err == nil || requestDone(ctx, err) || f.policy == FailClosed {
+		return d, err
+	}
+	f.logFn("限流器出错，降级处理", err)
+	if f.policy == FailFallback && f.fallback != nil {
+		return decide(ctx, f.fallback, key, cost)
+	}
+	return ratelimit.Decision{}, nil
+}
+
+// requestDone 请求自己取消了或者超时了，不是限流器的问题
+func requestDone(ctx *gin.Context, err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) && ctx.Request.Context().Err() != nil
+}
+
+// decide 使用请求的 context，这样请求结束之后漏桶不会继续等待
+func decide(gctx *gin.Context, limiter ratelimit.Limiter, key string, cost int64) (ratelimit.Decision, error) {
+	ctx := gctx.Request.Context()
+	if cl, ok := limiter.(ratelimit.CostLimiter); ok {
+		return cl.DecideN(ctx, key, cost)
+	}
+	if dl, ok := limiter.(ratelimit.DecisionLimiter); ok {
+		return dl.Decide(ctx, key)
+	}
+	limited, err := limiter.Limit(ctx, key)
+	return ratelimit.Decision{Limited: limited}, err
+}
+
+func abortLimited(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, ginx.Result{
+		Code: http.StatusTooManyRequests,
+		Msg:  "请求过于频繁，请稍后再试",
+	})
+}
+
+// writeHeaders 按照 IETF RateLimit header 草案设置响应头
+// 时间都是以秒为单位，向上取整
+func writeHeaders(ctx *gin.Context, d ratelimit.Decision) {
+	if d.Limit > 0 {
+		ctx.Header("RateLimit-Limit", strconv.FormatInt(d.Limit, 10))
+		ctx.Header("RateLimit-Remaining", strconv.FormatInt(d.Remaining, 10))
+		ctx.Header("RateLimit-Reset", seconds(d.Reset))
+	}
+	if d.Limited && d.RetryAfter > 0 {
+		ctx.Header("Retry-After", seconds(d.RetryAfter))
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
 }
@@ -15,15 +15,20 @@
 package ratelimit
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 
+	"github.com/ecodeclub/ginx/internal/mocks"
 	"github.com/ecodeclub/ginx/internal/ratelimit"
 	limitmocks "github.com/ecodeclub/ginx/internal/ratelimit/mocks"
 )
@@ -171,7 +176,7 @@ func TestBuilder_limit(t *testing.T) {
 		reqBuilder func(t *testing.T) *http.Request
 
 		// 预期响应
-		want    bool
+		want    ratelimit.Decision
 		wantErr error
 	}{
 		{
@@ -190,7 +195,7 @@ func TestBuilder_limit(t *testing.T) {
 				req.RemoteAddr = "127.0.0.1:80"
 				return req
 			},
-			want: false,
+			want: ratelimit.Decision{},
 		},
 		{
 			name: "限流",
@@ -208,7 +213,7 @@ func TestBuilder_limit(t *testing.T) {
 				req.RemoteAddr = "127.0.0.1:80"
 				return req
 			},
-			want: true,
+			want: ratelimit.Decision{Limited: true},
 		},
 		{
 			name: "限流代码出错",
@@ -226,9 +231,27 @@ func TestBuilder_limit(t *testing.T) {
 				req.RemoteAddr = "127.0.0.1:80"
 				return req
 			},
-			want:    false,
+			want:    ratelimit.Decision{},
 			wantErr: errors.New("模拟系统错误"),
 		},
+		{
+			name: "限流器提供更多信息",
+			mock: func(ctrl *gomock.Controller) ratelimit.Limiter {
+				limiter := limitmocks.NewMockDecisionLimiter(ctrl)
+				limiter.EXPECT().Decide(gomock.Any(), "ip-limiter:127.0.0.1").
+					Return(ratelimit.Decision{Limited: true, Limit: 10, RetryAfter: time.Second}, nil)
+				return limiter
+			},
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.RemoteAddr = "127.0.0.1:80"
+				return req
+			},
+			want: ratelimit.Decision{Limited: true, Limit: 10, RetryAfter: time.Second},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -242,13 +265,347 @@ func TestBuilder_limit(t *testing.T) {
 			req := tt.reqBuilder(t)
 			ctx.Request = req
 
-			got, err := b.limit(ctx)
+			got, err := b.limit(ctx, b.genKeyFn(ctx))
 			assert.Equal(t, tt.wantErr, err)
 			assert.Equal(t, tt.want, got)
 		})
 	}
 }
 
+func TestBuilder_BuildHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+
+		decision ratelimit.Decision
+
+		wantCode   int
+		wantHeader map[string]string
+		wantBody   string
+	}{
+		{
+			name: "不限流",
+			decision: ratelimit.Decision{
+				Limit:     10,
+				Remaining: 9,
+				Reset:     1500 * time.Millisecond,
+			},
+			wantCode: http.StatusOK,
+			wantHeader: map[string]string{
+				"RateLimit-Limit":     "10",
+				"RateLimit-Remaining": "9",
+				"RateLimit-Reset":     "2",
+				"Retry-After":         "",
+			},
+		},
+		{
+			name: "限流",
+			decision: ratelimit.Decision{
+				Limited:    true,
+				Limit:      10,
+				Remaining:  0,
+				Reset:      3 * time.Second,
+				RetryAfter: 200 * time.Millisecond,
+			},
+			wantCode: http.StatusTooManyRequests,
+			wantHeader: map[string]string{
+				"RateLimit-Limit":     "10",
+				"RateLimit-Remaining": "0",
+				"RateLimit-Reset":     "3",
+				"Retry-After":         "1",
+			},
+			wantBody: `{"code":429,"msg":"请求过于频繁，请稍后再试","data":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			limiter := limitmocks.NewMockDecisionLimiter(ctrl)
+			limiter.EXPECT().Decide(gomock.Any(), gomock.Any()).Return(tt.decision, nil)
+			svc := NewBuilder(limiter)
+
+			server := gin.Default()
+			server.Use(svc.Build())
+			svc.RegisterRoutes(server)
+
+			req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+			require.NoError(t, err)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			for k, v := range tt.wantHeader {
+				assert.Equal(t, v, recorder.Header().Get(k), k)
+			}
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestBuilder_SetCostFunc(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	limiter := limitmocks.NewMockCostLimiter(ctrl)
+	limiter.EXPECT().DecideN(gomock.Any(), gomock.Any(), int64(10)).
+		Return(ratelimit.Decision{Limited: true, Limit: 100}, nil)
+	svc := NewBuilder(limiter).SetCostFunc(CostByRoute(map[string]int64{
+		"/limit": 10,
+		// 健康检查不消耗配额
+		"/health": 0,
+	}, 1))
+
+	server := gin.Default()
+	server.Use(svc.Build())
+	svc.RegisterRoutes(server)
+	server.GET("/health", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+
+	req, err = http.NewRequest(http.MethodGet, "/health", nil)
+	require.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestBuilder_SetPenaltyBox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	limiter := limitmocks.NewMockDecisionLimiter(ctrl)
+	// 被封禁之后不会再访问限流器
+	limiter.EXPECT().Decide(gomock.Any(), gomock.Any()).
+		Return(ratelimit.Decision{Limited: true, Limit: 10, RetryAfter: time.Second}, nil)
+	cmd := mocks.NewMockCmdable(ctrl)
+	res := redis.NewCmd(context.Background())
+	res.SetVal([]any{int64(1), int64(60000), int64(1)})
+	cmd.EXPECT().Eval(gomock.Any(), gomock.Any(),
+		[]string{"penalty:{ip-limiter:127.0.0.1}:ban", "penalty:{ip-limiter:127.0.0.1}:violation",
+			"penalty:{ip-limiter:127.0.0.1}:level"}, gomock.Any()).Return(res)
+	// 第一个请求本地没有封禁记录，到 Redis 确认；第二个请求命中本地缓存
+	pttl := redis.NewDurationCmd(context.Background(), time.Millisecond)
+	pttl.SetVal(-2)
+	cmd.EXPECT().PTTL(gomock.Any(), "penalty:{ip-limiter:127.0.0.1}:ban").Return(pttl)
+
+	var (
+		bannedKey string
+		bannedFor time.Duration
+	)
+	svc := NewBuilder(limiter).SetPenaltyBox(NewRedisPenaltyBox(cmd,
+		WithPenaltyHook(func(key string, ban time.Duration, level int64) {
+			bannedKey = key
+			bannedFor = ban
+		})))
+	server := gin.Default()
+	server.Use(svc.Build())
+	svc.RegisterRoutes(server)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "127.0.0.1:80"
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "60", recorder.Header().Get("Retry-After"))
+	}
+	assert.Equal(t, "ip-limiter:127.0.0.1", bannedKey)
+	assert.Equal(t, time.Minute, bannedFor)
+}
+
+func TestBuilder_PenaltyBoxFailPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+
+		mock func(ctrl *gomock.Controller) *Builder
+
+		wantCodes []int
+	}{
+		{
+			name: "默认拒绝",
+			mock: func(ctrl *gomock.Controller) *Builder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				cmd := mocks.NewMockCmdable(ctrl)
+				pttl := redis.NewDurationCmd(context.Background(), time.Millisecond)
+				pttl.SetErr(errors.New("模拟系统错误"))
+				cmd.EXPECT().PTTL(gomock.Any(), gomock.Any()).Return(pttl)
+				return NewBuilder(limiter).SetPenaltyBox(NewRedisPenaltyBox(cmd))
+			},
+			wantCodes: []int{http.StatusInternalServerError},
+		},
+		{
+			name: "熔断之后不再查询封禁",
+			mock: func(ctrl *gomock.Controller) *Builder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				cmd := mocks.NewMockCmdable(ctrl)
+				pttl := redis.NewDurationCmd(context.Background(), time.Millisecond)
+				pttl.SetErr(errors.New("模拟系统错误"))
+				cmd.EXPECT().PTTL(gomock.Any(), gomock.Any()).Return(pttl)
+				return NewBuilder(limiter).
+					SetFailPolicy(FailOpen).
+					SetCircuitBreaker(1, time.Minute).
+					SetPenaltyBox(NewRedisPenaltyBox(cmd, WithPenaltyNegativeTTL(0)))
+			},
+			// 第一个请求查询封禁出错之后就熔断了，之后既不查询封禁，也不访问限流器
+			wantCodes: []int{http.StatusOK, http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			svc := tt.mock(ctrl)
+			server := gin.Default()
+			server.Use(svc.Build())
+			svc.RegisterRoutes(server)
+			for _, code := range tt.wantCodes {
+				req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+				require.NoError(t, err)
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, req)
+				assert.Equal(t, code, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestBuilder_FailPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+
+		mock func(ctrl *gomock.Controller) *Builder
+
+		wantCodes []int
+	}{
+		{
+			name: "默认拒绝",
+			mock: func(ctrl *gomock.Controller) *Builder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				limiter.EXPECT().Limit(gomock.Any(), gomock.Any()).
+					Return(false, errors.New("模拟系统错误"))
+				return NewBuilder(limiter)
+			},
+			wantCodes: []int{http.StatusInternalServerError},
+		},
+		{
+			name: "放行",
+			mock: func(ctrl *gomock.Controller) *Builder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				limiter.EXPECT().Limit(gomock.Any(), gomock.Any()).
+					Return(false, errors.New("模拟系统错误"))
+				return NewBuilder(limiter).SetFailPolicy(FailOpen)
+			},
+			wantCodes: []int{http.StatusOK},
+		},
+		{
+			name: "降级为本地限流",
+			mock: func(ctrl *gomock.Controller) *Builder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				limiter.EXPECT().Limit(gomock.Any(), gomock.Any()).
+					Return(false, errors.New("模拟系统错误")).Times(2)
+				return NewBuilder(limiter).
+					SetFallbackLimiter(NewLocalSlidingWindowLimiter(time.Minute, 1))
+			},
+			wantCodes: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name: "熔断之后不再访问限流器",
+			mock: func(ctrl *gomock.Controller) *Builder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				limiter.EXPECT().Limit(gomock.Any(), gomock.Any()).
+					Return(false, errors.New("模拟系统错误")).Times(2)
+				return NewBuilder(limiter).
+					SetFailPolicy(FailOpen).
+					SetCircuitBreaker(2, time.Minute)
+			},
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			svc := tt.mock(ctrl).SetLogFunc(func(msg any, args ...any) {})
+
+			server := gin.Default()
+			server.Use(svc.Build())
+			svc.RegisterRoutes(server)
+
+			for _, code := range tt.wantCodes {
+				req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+				require.NoError(t, err)
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, req)
+				assert.Equal(t, code, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestBuilder_RequestTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	limiter := limitmocks.NewMockLimiter(ctrl)
+	// 请求自己超时了，不会触发熔断，也不会降级
+	limiter.EXPECT().Limit(gomock.Any(), gomock.Any()).
+		Return(false, context.DeadlineExceeded).Times(2)
+	fallback := limitmocks.NewMockLimiter(ctrl)
+	svc := NewBuilder(limiter).
+		SetFallbackLimiter(fallback).
+		SetCircuitBreaker(1, time.Minute).
+		SetLogFunc(func(msg any, args ...any) {})
+
+	server := gin.New()
+	server.Use(svc.Build())
+	svc.RegisterRoutes(server)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/limit", nil)
+		require.NoError(t, err)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestBuilder_SetShadowMode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	limiter := limitmocks.NewMockDecisionLimiter(ctrl)
+	limiter.EXPECT().Decide(gomock.Any(), "ip-limiter:127.0.0.1").
+		Return(ratelimit.Decision{Limited: true, Limit: 10, RetryAfter: time.Second}, nil).Times(2)
+
+	var keys []string
+	svc := NewBuilder(limiter).
+		SetShadowMode(true).
+		SetShadowHook(func(ctx *gin.Context, rule string, key string, d ratelimit.Decision) {
+			assert.True(t, d.Limited)
+			keys = append(keys, key)
+		})
+	server := gin.Default()
+	server.Use(svc.Build())
+	svc.RegisterRoutes(server)
+
+	// 影子模式下放行，并且不会设置响应头；关闭之后立刻生效
+	for _, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "127.0.0.1:80"
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, code, recorder.Code)
+		svc.SetShadowMode(false)
+	}
+	assert.Equal(t, []string{"ip-limiter:127.0.0.1"}, keys)
+}
+
 func (b *Builder) RegisterRoutes(server *gin.Engine) {
 	server.GET("/limit", func(ctx *gin.Context) {
 		ctx.Status(http.StatusOK)
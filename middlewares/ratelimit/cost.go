@@ -0,0 +1,69 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CostFunc 计算一个请求要消耗多少个配额.
+// 返回值小于等于 0 表示这个请求不消耗配额, 也就是不限流
+type CostFunc func(ctx *gin.Context) int64
+
+// CostByRoute 按照路由设置开销.
+// costs 的 key 可以是 "GET /users/:id" 这种方法加路由模式的形式, 也可以只有路由模式,
+// 优先匹配带方法的. 没有匹配上的路由使用 defaultCost
+func CostByRoute(costs map[string]int64, defaultCost int64) CostFunc {
+	return func(ctx *gin.Context) int64 {
+		path := ctx.FullPath()
+		if cost, ok := costs[ctx.Request.Method+" "+path]; ok {
+			return cost
+		}
+		if cost, ok := costs[path]; ok {
+			return cost
+		}
+		return defaultCost
+	}
+}
+
+// CostByHeader 从请求头中读取开销, 没有或者不合法的时候使用 defaultCost.
+// 请求头可以被客户端伪造, 所以只能用于网关之类的可信来源设置的请求头
+func CostByHeader(name string, defaultCost int64) CostFunc {
+	return func(ctx *gin.Context) int64 {
+		cost, err := strconv.ParseInt(ctx.GetHeader(name), 10, 64)
+		if err != nil || cost < 1 {
+			return defaultCost
+		}
+		return cost
+	}
+}
+
+// CostBySize 按照请求体的大小计算开销, 每 unit 个字节消耗一个配额, 向上取整.
+// 最少消耗一个配额, 不知道大小的请求(例如 chunked)也是一个配额.
+// unit 小于 1 的时候按照 1 处理, 也就是每个字节一个配额
+func CostBySize(unit int64) CostFunc {
+	if unit < 1 {
+		unit = 1
+	}
+	return func(ctx *gin.Context) int64 {
+		size := ctx.Request.ContentLength
+		if size <= unit {
+			return 1
+		}
+		return (size + unit - 1) / unit
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostFunc(t *testing.T) {
+	tests := []struct {
+		name   string
+		fn     CostFunc
+		method string
+		path   string
+		header map[string]string
+		body   string
+
+		want int64
+	}{
+		{
+			name: "路由加方法",
+			fn: CostByRoute(map[string]int64{
+				"POST /export": 10,
+				"/export":      5,
+			}, 1),
+			method: http.MethodPost,
+			path:   "/export",
+			want:   10,
+		},
+		{
+			name: "只有路由",
+			fn: CostByRoute(map[string]int64{
+				"POST /export": 10,
+				"/export":      5,
+			}, 1),
+			method: http.MethodGet,
+			path:   "/export",
+			want:   5,
+		},
+		{
+			name:   "没有匹配的路由",
+			fn:     CostByRoute(map[string]int64{"/export": 5}, 1),
+			method: http.MethodGet,
+			path:   "/search",
+			want:   1,
+		},
+		{
+			name:   "请求头",
+			fn:     CostByHeader("X-Cost", 1),
+			method: http.MethodGet,
+			path:   "/search",
+			header: map[string]string{"X-Cost": "8"},
+			want:   8,
+		},
+		{
+			name:   "请求头不合法",
+			fn:     CostByHeader("X-Cost", 2),
+			method: http.MethodGet,
+			path:   "/search",
+			header: map[string]string{"X-Cost": "-8"},
+			want:   2,
+		},
+		{
+			name:   "请求体大小",
+			fn:     CostBySize(4),
+			method: http.MethodPost,
+			path:   "/export",
+			body:   "123456789",
+			want:   3,
+		},
+		{
+			name:   "unit 不合法",
+			fn:     CostBySize(0),
+			method: http.MethodPost,
+			path:   "/export",
+			body:   "123456789",
+			want:   9,
+		},
+		{
+			name:   "空请求体",
+			fn:     CostBySize(4),
+			method: http.MethodGet,
+			path:   "/export",
+			want:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int64
+			server := gin.New()
+			handler := func(ctx *gin.Context) {
+				got = tt.fn(ctx)
+			}
+			server.GET("/export", handler)
+			server.POST("/export", handler)
+			server.GET("/search", handler)
+
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
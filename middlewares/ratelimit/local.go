@@ -0,0 +1,63 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+)
+
+// LocalOptions 本地限流器的配置
+type LocalOptions = ratelimit.LocalOptions
+
+// WithShards 设置本地限流器的分片数量, 默认 32
+func WithShards(shards int) option.Option[LocalOptions] {
+	return ratelimit.WithShards(shards)
+}
+
+// WithMaxKeys 设置本地限流器最多保存多少个 key 的状态, 默认 100000.
+// 超过之后淘汰最久没有访问的 key, 用于限制内存
+func WithMaxKeys(maxKeys int) option.Option[LocalOptions] {
+	return ratelimit.WithMaxKeys(maxKeys)
+}
+
+// NewLocalSlidingWindowLimiter 创建一个本地的滑动窗口限流器.
+// interval: 窗口大小
+// rate: 阈值
+// 表示: 在 interval 内允许 rate 个请求
+func NewLocalSlidingWindowLimiter(interval time.Duration, rate int,
+	opts ...option.Option[LocalOptions]) ratelimit.Limiter {
+	return ratelimit.NewLocalSlidingWindowLimiter(interval, rate, opts...)
+}
+
+// NewLocalTokenBucketLimiter 创建一个本地的令牌桶限流器.
+// capacity: 桶的容量, 也就是允许的最大突发请求数
+// interval, rate: 每 interval 放入 rate 个令牌
+// 三个参数都必须大于 0，否则 panic
+func NewLocalTokenBucketLimiter(capacity int, interval time.Duration, rate int,
+	opts ...option.Option[LocalOptions]) ratelimit.Limiter {
+	return ratelimit.NewLocalTokenBucketLimiter(capacity, interval, rate, opts...)
+}
+
+// NewLocalLeakyBucketLimiter 创建一个本地的漏桶限流器.
+// 请求会排队, 以每 interval 放行 rate 个的速度匀速通过.
+// capacity: 最多排队的请求数, 超过之后的请求会被限流
+func NewLocalLeakyBucketLimiter(capacity int, interval time.Duration, rate int,
+	opts ...option.Option[LocalOptions]) ratelimit.Limiter {
+	return ratelimit.NewLocalLeakyBucketLimiter(capacity, interval, rate, opts...)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+)
+
+// PenaltyBox 对频繁触发限流的 key 进行封禁, 可以调用 Lift 解除封禁
+type PenaltyBox = ratelimit.PenaltyBox
+
+// NewRedisPenaltyBox 创建一个基于 redis 的封禁.
+// 默认 1 分钟内被限流 10 次就封禁, 封禁时间从 1 分钟开始翻倍, 最长 1 天.
+// 封禁记录会缓存在本地直到封禁结束, 被封禁的请求不会访问 redis.
+// 没有被封禁的结果在本地缓存 1 秒, 正常的请求不会每次都查询 redis
+func NewRedisPenaltyBox(cmd redis.Cmdable, opts ...option.Option[PenaltyBox]) *PenaltyBox {
+	return ratelimit.NewPenaltyBox(cmd, opts...)
+}
+
+// WithPenaltyThreshold window 内被限流 threshold 次之后封禁
+func WithPenaltyThreshold(threshold int, window time.Duration) option.Option[PenaltyBox] {
+	return ratelimit.WithPenaltyThreshold(threshold, window)
+}
+
+// WithPenaltyBan 第一次封禁 baseBan, 之后每次翻倍, 最长 maxBan
+func WithPenaltyBan(baseBan, maxBan time.Duration) option.Option[PenaltyBox] {
+	return ratelimit.WithPenaltyBan(baseBan, maxBan)
+}
+
+// WithPenaltyDecay 超过 decay 没有被封禁, 封禁时间就从头开始算
+func WithPenaltyDecay(decay time.Duration) option.Option[PenaltyBox] {
+	return ratelimit.WithPenaltyDecay(decay)
+}
+
+// WithPenaltyLocalTTL 本地缓存封禁记录的最长时间, 也就是 Lift 在其它实例上生效的最长延迟.
+// 默认一直缓存到封禁结束
+func WithPenaltyLocalTTL(ttl time.Duration) option.Option[PenaltyBox] {
+	return ratelimit.WithPenaltyLocalTTL(ttl)
+}
+
+// WithPenaltyNegativeTTL 本地缓存没有被封禁的结果的时间, 也就是别的实例上的封禁在当前实例上生效的最长延迟.
+// 默认 1 秒, 小于等于 0 表示每次都查询 redis
+func WithPenaltyNegativeTTL(ttl time.Duration) option.Option[PenaltyBox] {
+	return ratelimit.WithPenaltyNegativeTTL(ttl)
+}
+
+// WithPenaltyHook 封禁的时候回调, 例如打日志或者告警
+func WithPenaltyHook(fn func(key string, ban time.Duration, level int64)) option.Option[PenaltyBox] {
+	return ratelimit.WithPenaltyHook(fn)
+}
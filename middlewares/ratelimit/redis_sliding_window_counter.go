@@ -0,0 +1,36 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+)
+
+// NewRedisSlidingWindowCounterLimiter 创建一个基于 redis 的滑动窗口计数器限流器.
+// 和 NewRedisSlidingWindowLimiter 的参数含义一样, 但是每个 key 只保存两个计数,
+// 不管流量多大内存占用都是固定的, 代价是限流的结果是近似的.
+// 适合流量很大的 key
+func NewRedisSlidingWindowCounterLimiter(cmd redis.Cmdable,
+	interval time.Duration, rate int) ratelimit.Limiter {
+	return &ratelimit.RedisSlidingWindowCounterLimiter{
+		Cmd:      cmd,
+		Interval: interval,
+		Rate:     rate,
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+)
+
+// NewRedisTokenBucketLimiter 创建一个基于 redis 的令牌桶限流器.
+// cmd: 可传入 redis 的客户端
+// capacity: 桶的容量, 也就是允许的最大突发请求数
+// interval, rate: 每 interval 放入 rate 个令牌
+// 示例: 每秒 100 个请求, 允许突发到 300 个请求
+// NewRedisTokenBucketLimiter(cmd, 300, time.Second, 100)
+// capacity、interval 和 rate 都必须大于 0，否则 panic
+func NewRedisTokenBucketLimiter(cmd redis.Cmdable, capacity int,
+	interval time.Duration, rate int) ratelimit.Limiter {
+	return ratelimit.NewRedisTokenBucketLimiter(cmd, capacity, interval, rate)
+}
@@ -0,0 +1,290 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/atomic"
+
+	"github.com/ecodeclub/ginx/internal/breaker"
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+	"github.com/ecodeclub/ginx/session"
+)
+
+// KeyFunc 从请求中提取限流的维度.
+// 返回空字符串表示这条规则不适用于这个请求, 例如没有登录的请求没有 uid
+type KeyFunc func(ctx *gin.Context) string
+
+// KeyByGlobal 所有请求共享同一个限流 key
+func KeyByGlobal() KeyFunc {
+	return func(ctx *gin.Context) string {
+		return "global"
+	}
+}
+
+// KeyByIP 按照客户端 IP 限流
+func KeyByIP() KeyFunc {
+	return func(ctx *gin.Context) string {
+		return ctx.ClientIP()
+	}
+}
+
+// KeyByUid 按照用户限流.
+// 需要放在登录校验的 middleware 之后, 从 session.CtxSessionKey 中读取 session
+func KeyByUid() KeyFunc {
+	return func(ctx *gin.Context) string {
+		sess, ok := ctxSession(ctx)
+		if !ok {
+			return ""
+		}
+		return strconv.FormatInt(sess.Claims().Uid, 10)
+	}
+}
+
+// KeyByClaim 按照 JWT 中的某个数据限流, 例如租户 ID
+func KeyByClaim(key string) KeyFunc {
+	return func(ctx *gin.Context) string {
+		sess, ok := ctxSession(ctx)
+		if !ok {
+			return ""
+		}
+		return sess.Claims().Data[key]
+	}
+}
+
+// KeyByHeader 按照某个请求头限流, 例如 API key
+func KeyByHeader(name string) KeyFunc {
+	return func(ctx *gin.Context) string {
+		return ctx.GetHeader(name)
+	}
+}
+
+func ctxSession(ctx *gin.Context) (session.Session, bool) {
+	val, ok := ctx.Get(session.CtxSessionKey)
+	if !ok {
+		return nil, false
+	}
+	sess, ok := val.(session.Session)
+	return sess, ok
+}
+
+// Rule 一条限流规则
+type Rule struct {
+	// Name 规则的名字, 会作为限流 key 的前缀, 所以不同的规则不能重名
+	Name string
+	// Path 注册路由时使用的模式, 例如 /users/:id.
+	// 以 * 结尾表示前缀匹配, 为空表示匹配所有路由
+	Path string
+	// Methods 为空表示匹配所有的 HTTP 方法
+	Methods []string
+	// Key 为 nil 的时候按照 IP 限流
+	Key KeyFunc
+	// Cost 为 nil 的时候每个请求消耗一个配额
+	Cost    CostFunc
+	Limiter ratelimit.Limiter
+	// Fallback 策略是 FailFallback 的时候使用的降级限流器, 为 nil 的时候放行
+	Fallback ratelimit.Limiter
+
+	// 在 RuleBuilder.rules 中的下标, 用于找到这条规则的熔断器
+	idx int
+}
+
+func (r Rule) match(method, path string) bool {
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Path == "" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return r.Path == path
+}
+
+// RuleBuilder 按照规则限流.
+// 一个请求命中多条规则的时候, 所有的规则都要满足, 例如全局 + 用户 + IP.
+// 规则按照添加的顺序执行, 遇到第一条限流的规则就会返回 429,
+// 前面已经通过的规则的配额不会归还
+type RuleBuilder struct {
+	rules []Rule
+	logFn func(msg any, args ...any)
+	// method + 路由模式 => 命中的规则, 路由是有限的, 所以缓存不会无限增长
+	matched sync.Map
+
+	shadow   *atomic.Bool
+	shadowFn ShadowFunc
+
+	policy FailPolicy
+	// 每条规则一个熔断器, 一条规则的限流器出问题不影响其它规则
+	breakers []*breaker.Breaker
+}
+
+func NewRuleBuilder(rules ...Rule) *RuleBuilder {
+	for i := range rules {
+		if rules[i].Key == nil {
+			rules[i].Key = KeyByIP()
+		}
+		rules[i].idx = i
+	}
+	breakers := make([]*breaker.Breaker, len(rules))
+	for i := range breakers {
+		breakers[i] = breaker.NewBreaker()
+	}
+	b := &RuleBuilder{
+		rules: rules,
+		logFn: func(msg any, args ...any) {
+			v := make([]any, 0, len(args)+1)
+			v = append(v, msg)
+			v = append(v, args...)
+			log.Println(v...)
+		},
+		shadow:   atomic.NewBool(false),
+		breakers: breakers,
+	}
+	b.shadowFn = func(ctx *gin.Context, rule string, key string, d ratelimit.Decision) {
+		b.logFn("影子模式，请求本来会被限流", rule, key)
+	}
+	return b
+}
+
+func (b *RuleBuilder) SetLogFunc(fn func(msg any, args ...any)) *RuleBuilder {
+	b.logFn = fn
+	return b
+}
+
+// SetShadowMode 开启或者关闭影子模式, 可以在运行期间调用.
+// 影子模式下所有的规则都会执行, 每一条本来会限流的规则都会回调 ShadowFunc, 但是请求都会放行
+func (b *RuleBuilder) SetShadowMode(enabled bool) *RuleBuilder {
+	b.shadow.Store(enabled)
+	return b
+}
+
+// SetShadowHook 默认使用 logFn 记录下来
+func (b *RuleBuilder) SetShadowHook(fn ShadowFunc) *RuleBuilder {
+	b.shadowFn = fn
+	return b
+}
+
+// SetFailPolicy 设置限流器出错时候的策略, 所有的规则共用.
+// FailFallback 的时候使用规则的 Fallback 限流器
+func (b *RuleBuilder) SetFailPolicy(policy FailPolicy) *RuleBuilder {
+	b.policy = policy
+	return b
+}
+
+// SetCircuitBreaker 某条规则的限流器连续出错 threshold 次之后熔断,
+// 熔断期间直接按照 FailPolicy 处理, cooldown 之后尝试恢复
+func (b *RuleBuilder) SetCircuitBreaker(threshold int, cooldown time.Duration) *RuleBuilder {
+	for i := range b.breakers {
+		b.breakers[i] = breaker.NewBreaker(breaker.WithThreshold(threshold), breaker.WithCooldown(cooldown))
+	}
+	return b
+}
+
+func (b *RuleBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var (
+			res   ratelimit.Decision
+			found bool
+		)
+		shadow := b.shadow.Load()
+		for _, r := range b.match(ctx.Request.Method, ctx.FullPath()) {
+			key := r.Key(ctx)
+			if key == "" {
+				continue
+			}
+			cost := int64(1)
+			if r.Cost != nil {
+				if cost = r.Cost(ctx); cost <= 0 {
+					continue
+				}
+			}
+			d, err := failover{
+				policy:   b.policy,
+				fallback: r.Fallback,
+				breaker:  b.breakers[r.idx],
+				logFn:    b.logFn,
+			}.decide(ctx, r.Limiter, r.Name+":"+key, cost)
+			if shadow {
+				if err != nil {
+					b.logFn(err)
+				} else if d.Limited {
+					b.shadowFn(ctx, r.Name, key, d)
+				}
+				continue
+			}
+			if err != nil {
+				b.logFn(err)
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			if d.Limited {
+				writeHeaders(ctx, d)
+				abortLimited(ctx)
+				return
+			}
+			// 响应头以剩余配额最少的规则为准
+			if d.Limit > 0 && (!found || d.Remaining < res.Remaining) {
+				res = d
+				found = true
+			}
+		}
+		if !shadow {
+			writeHeaders(ctx, res)
+		}
+		ctx.Next()
+	}
+}
+
+func (b *RuleBuilder) match(method, path string) []Rule {
+	// 没有命中路由的请求, method 可以是任意值, 所以不缓存
+	if path == "" {
+		return b.doMatch(method, path)
+	}
+	cacheKey := method + " " + path
+	if val, ok := b.matched.Load(cacheKey); ok {
+		return val.([]Rule)
+	}
+	res := b.doMatch(method, path)
+	b.matched.Store(cacheKey, res)
+	return res
+}
+
+func (b *RuleBuilder) doMatch(method, path string) []Rule {
+	res := make([]Rule, 0, len(b.rules))
+	for _, r := range b.rules {
+		if r.match(method, path) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
@@ -0,0 +1,332 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+	limitmocks "github.com/ecodeclub/ginx/internal/ratelimit/mocks"
+	"github.com/ecodeclub/ginx/session"
+)
+
+func TestRule_match(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		method string
+		path   string
+		want   bool
+	}{
+		{
+			name:   "匹配所有",
+			rule:   Rule{},
+			method: http.MethodGet,
+			path:   "/users/:id",
+			want:   true,
+		},
+		{
+			name:   "路由匹配",
+			rule:   Rule{Path: "/users/:id"},
+			method: http.MethodGet,
+			path:   "/users/:id",
+			want:   true,
+		},
+		{
+			name:   "路由不匹配",
+			rule:   Rule{Path: "/users/:id"},
+			method: http.MethodGet,
+			path:   "/users/:id/profile",
+			want:   false,
+		},
+		{
+			name:   "前缀匹配",
+			rule:   Rule{Path: "/users/*"},
+			method: http.MethodGet,
+			path:   "/users/:id/profile",
+			want:   true,
+		},
+		{
+			name:   "方法匹配",
+			rule:   Rule{Path: "/users/:id", Methods: []string{"post", http.MethodPut}},
+			method: http.MethodPost,
+			path:   "/users/:id",
+			want:   true,
+		},
+		{
+			name:   "方法不匹配",
+			rule:   Rule{Methods: []string{http.MethodPost}},
+			method: http.MethodGet,
+			path:   "/users/:id",
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rule.match(tt.method, tt.path))
+		})
+	}
+}
+
+func TestRuleBuilder_Build(t *testing.T) {
+	b := NewRuleBuilder(
+		Rule{
+			Name:    "global",
+			Key:     KeyByGlobal(),
+			Limiter: NewLocalSlidingWindowLimiter(time.Minute, 7),
+		},
+		Rule{
+			Name:    "ip",
+			Path:    "/users/*",
+			Limiter: NewLocalSlidingWindowLimiter(time.Minute, 2),
+		},
+		Rule{
+			Name:    "uid",
+			Path:    "/users/:id",
+			Methods: []string{http.MethodPost},
+			Key:     KeyByUid(),
+			Limiter: NewLocalSlidingWindowLimiter(time.Minute, 1),
+		},
+		Rule{
+			Name:    "api_key",
+			Path:    "/open",
+			Key:     KeyByHeader("X-Api-Key"),
+			Limiter: NewLocalSlidingWindowLimiter(time.Minute, 1),
+		},
+	)
+	server := gin.New()
+	server.Use(func(ctx *gin.Context) {
+		if uid := ctx.GetHeader("X-Uid"); uid != "" {
+			id, _ := strconv.ParseInt(uid, 10, 64)
+			ctx.Set(session.CtxSessionKey, session.NewMemorySession(session.Claims{Uid: id}))
+		}
+	}, b.Build())
+	ok := func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	}
+	server.GET("/users/:id", ok)
+	server.POST("/users/:id", ok)
+	server.GET("/open", ok)
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		ip     string
+		header map[string]string
+
+		wantCode      int
+		wantRemaining string
+	}{
+		{
+			name:   "只命中全局规则",
+			method: http.MethodGet, path: "/open", ip: "10.0.0.1",
+			wantCode: http.StatusOK, wantRemaining: "6",
+		},
+		{
+			name:   "命中全局和 IP 规则, 以剩余最少的为准",
+			method: http.MethodGet, path: "/users/1", ip: "10.0.0.1",
+			wantCode: http.StatusOK, wantRemaining: "1",
+		},
+		{
+			name:   "命中全部三条规则",
+			method: http.MethodPost, path: "/users/1", ip: "10.0.0.1",
+			header:   map[string]string{"X-Uid": "123"},
+			wantCode: http.StatusOK, wantRemaining: "0",
+		},
+		{
+			name:   "同一个用户换了 IP 也会被限流",
+			method: http.MethodPost, path: "/users/1", ip: "10.0.0.2",
+			header:   map[string]string{"X-Uid": "123"},
+			wantCode: http.StatusTooManyRequests, wantRemaining: "0",
+		},
+		{
+			name:   "另外一个用户",
+			method: http.MethodPost, path: "/users/1", ip: "10.0.0.2",
+			header:   map[string]string{"X-Uid": "456"},
+			wantCode: http.StatusOK, wantRemaining: "0",
+		},
+		{
+			name:   "同一个 IP 超过限制",
+			method: http.MethodGet, path: "/users/2", ip: "10.0.0.1",
+			wantCode: http.StatusTooManyRequests, wantRemaining: "0",
+		},
+		{
+			name:   "API key",
+			method: http.MethodGet, path: "/open", ip: "10.0.0.3",
+			header:   map[string]string{"X-Api-Key": "abc"},
+			wantCode: http.StatusOK, wantRemaining: "0",
+		},
+		{
+			name:   "全局超过限制",
+			method: http.MethodGet, path: "/open", ip: "10.0.0.3",
+			wantCode: http.StatusTooManyRequests, wantRemaining: "0",
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			req, err := http.NewRequest(step.method, step.path, nil)
+			require.NoError(t, err)
+			req.RemoteAddr = step.ip + ":80"
+			for k, v := range step.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, step.wantCode, recorder.Code)
+			assert.Equal(t, step.wantRemaining, recorder.Header().Get("RateLimit-Remaining"))
+		})
+	}
+}
+
+func TestRuleBuilder_BuildError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	limiter := limitmocks.NewMockLimiter(ctrl)
+	limiter.EXPECT().Limit(gomock.Any(), "global:global").
+		Return(false, errors.New("模拟系统错误"))
+
+	server := gin.New()
+	server.Use(NewRuleBuilder(Rule{
+		Name:    "global",
+		Key:     KeyByGlobal(),
+		Limiter: limiter,
+	}).Build())
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestRuleBuilder_FailPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+
+		mock func(ctrl *gomock.Controller) *RuleBuilder
+
+		wantCodes []int
+	}{
+		{
+			name: "放行",
+			mock: func(ctrl *gomock.Controller) *RuleBuilder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				limiter.EXPECT().Limit(gomock.Any(), "global:global").
+					Return(false, errors.New("模拟系统错误"))
+				return NewRuleBuilder(Rule{Name: "global", Key: KeyByGlobal(), Limiter: limiter}).
+					SetFailPolicy(FailOpen)
+			},
+			wantCodes: []int{http.StatusOK},
+		},
+		{
+			name: "降级为本地限流",
+			mock: func(ctrl *gomock.Controller) *RuleBuilder {
+				limiter := limitmocks.NewMockLimiter(ctrl)
+				limiter.EXPECT().Limit(gomock.Any(), "global:global").
+					Return(false, errors.New("模拟系统错误")).Times(2)
+				return NewRuleBuilder(Rule{
+					Name:     "global",
+					Key:      KeyByGlobal(),
+					Limiter:  limiter,
+					Fallback: NewLocalSlidingWindowLimiter(time.Minute, 1),
+				}).SetFailPolicy(FailFallback)
+			},
+			wantCodes: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name: "熔断只影响出错的规则",
+			mock: func(ctrl *gomock.Controller) *RuleBuilder {
+				broken := limitmocks.NewMockLimiter(ctrl)
+				broken.EXPECT().Limit(gomock.Any(), "global:global").
+					Return(false, errors.New("模拟系统错误")).Times(2)
+				ok := limitmocks.NewMockLimiter(ctrl)
+				ok.EXPECT().Limit(gomock.Any(), gomock.Any()).Return(false, nil).Times(4)
+				return NewRuleBuilder(
+					Rule{Name: "global", Key: KeyByGlobal(), Limiter: broken},
+					Rule{Name: "api", Key: KeyByGlobal(), Limiter: ok},
+				).SetFailPolicy(FailOpen).SetCircuitBreaker(2, time.Minute)
+			},
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			b := tt.mock(ctrl).SetLogFunc(func(msg any, args ...any) {})
+
+			server := gin.New()
+			server.Use(b.Build())
+			server.GET("/limit", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			for _, code := range tt.wantCodes {
+				req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+				require.NoError(t, err)
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, req)
+				assert.Equal(t, code, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestRuleBuilder_SetShadowMode(t *testing.T) {
+	var rules []string
+	b := NewRuleBuilder(
+		Rule{
+			Name:    "global",
+			Key:     KeyByGlobal(),
+			Limiter: NewLocalSlidingWindowLimiter(time.Minute, 1),
+		},
+		Rule{
+			Name:    "ip",
+			Limiter: NewLocalSlidingWindowLimiter(time.Minute, 1),
+		},
+	).SetShadowMode(true).
+		SetShadowHook(func(ctx *gin.Context, rule string, key string, d ratelimit.Decision) {
+			rules = append(rules, rule+":"+key)
+		})
+	server := gin.New()
+	server.Use(b.Build())
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "10.0.0.1:80"
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("RateLimit-Remaining"))
+	}
+	// 影子模式下所有的规则都会执行
+	assert.Equal(t, []string{"global:global", "ip:10.0.0.1"}, rules)
+}
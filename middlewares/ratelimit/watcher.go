@@ -0,0 +1,56 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ecodeclub/ginx/internal/ratelimit"
+)
+
+// Reconfigurable 可以在运行期间修改阈值的限流器.
+// NewRedisSlidingWindowLimiter 和 NewRedisSlidingWindowCounterLimiter 创建的限流器都实现了这个接口
+type Reconfigurable = ratelimit.Reconfigurable
+
+// ConfigSource 限流配置的来源
+type ConfigSource = ratelimit.ConfigSource
+
+// Watcher 定时加载限流配置, 配置变化的时候修改限流器的阈值, 不需要重新构建 middleware.
+// Start 只有在读取配置失败的时候返回 error, 不合法的配置项会通过日志记录, 不影响其它的配置项.
+// 例如:
+//
+//	limiter := NewRedisSlidingWindowLimiter(cmd, time.Second, 3000)
+//	w := NewWatcher(NewRedisHashSource(cmd, "ratelimit:config"), 10*time.Second).
+//		WatchRate("api", limiter.(Reconfigurable)).
+//		WatchMaxActive("active", func(n int64) { activeLimit.SetMaxActive(n) })
+//	err := w.Start(ctx)
+type Watcher = ratelimit.Watcher
+
+// NewWatcher 每 interval 从 source 加载一次配置
+func NewWatcher(source ConfigSource, interval time.Duration) *Watcher {
+	return ratelimit.NewWatcher(source, interval)
+}
+
+// NewRedisHashSource 从 redis 的 hash 中读取配置, field 是 Watch 时使用的名字
+func NewRedisHashSource(cmd redis.Cmdable, key string) ConfigSource {
+	return ratelimit.NewRedisHashSource(cmd, key)
+}
+
+// NewFileSource 从本地的 JSON 文件读取配置, 例如 {"api": "3000/1s", "active": 100}
+func NewFileSource(path string) ConfigSource {
+	return ratelimit.NewFileSource(path)
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dpop
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReplayCache 记录已经使用过的 proof jti，用于拒绝重放
+type ReplayCache interface {
+	// Add 记录 jti，在 ttl 内重复记录同一个 jti 会返回 false
+	Add(ctx context.Context, jti string, ttl time.Duration) (bool, error)
+}
+
+var _ ReplayCache = &RedisReplayCache{}
+
+// RedisReplayCache 基于 SETNX 实现，多个实例之间共享
+type RedisReplayCache struct {
+	client redis.Cmdable
+	prefix string
+}
+
+func NewRedisReplayCache(client redis.Cmdable) *RedisReplayCache {
+	return &RedisReplayCache{
+		client: client,
+		prefix: "dpop:jti:",
+	}
+}
+
+func (r *RedisReplayCache) Add(ctx context.Context, jti string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.prefix+jti, 1, ttl).Result()
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dpop
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/ginx/gctx"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HeaderName 是客户端携带 DPoP proof 的请求头
+const HeaderName = "DPoP"
+
+const (
+	proofType = "dpop+jwt"
+	// ctxVerifiedKey 同一个请求内已经校验过的 jkt，避免重复校验被当成重放
+	ctxVerifiedKey = "_dpop_jkt"
+)
+
+var (
+	ErrProofMissing  = errors.New("缺少 DPoP proof")
+	ErrInvalidProof  = errors.New("DPoP proof 不合法")
+	ErrProofReplayed = errors.New("DPoP proof 被重放")
+	ErrKeyMismatch   = errors.New("DPoP proof 的公钥和 token 绑定的公钥不一致")
+)
+
+// ProofClaims 是 DPoP proof 的 payload
+type ProofClaims struct {
+	// HTM HTTP 方法
+	HTM string `json:"htm"`
+	// HTU 不包含 query 和 fragment 的请求 URL
+	HTU string `json:"htu"`
+	// ATH access token 的 SHA-256 哈希，base64url 编码。登录的时候没有 access token，所以可以没有
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier 校验 DPoP proof
+// 参考 RFC 9449，proof 是客户端用自己的私钥签名的 JWT，
+// 头部携带公钥，payload 绑定了本次请求的方法和 URL
+type Verifier struct {
+	cache ReplayCache
+	// proof 的 iat 和当前时间允许的最大偏差
+	window  time.Duration
+	nowFunc func() time.Time
+	// htuFunc 计算当前请求的 URL，用于和 proof 里面的 htu 比较
+	htuFunc func(ctx *gctx.Context) string
+	methods []string
+}
+
+// NewVerifier 创建 Verifier
+// window: 默认一分钟
+// htuFunc: 默认根据 TLS 或者 X-Forwarded-Proto 判断 scheme，再拼接 Host 和 Path
+func NewVerifier(cache ReplayCache, opts ...option.Option[Verifier]) *Verifier {
+	res := &Verifier{
+		cache:   cache,
+		window:  time.Minute,
+		nowFunc: time.Now,
+		htuFunc: defaultHTU,
+		methods: []string{"ES256", "ES384", "ES512",
+			"RS256", "RS384", "RS512",
+			"PS256", "PS384", "PS512", "EdDSA"},
+	}
+	option.Apply[Verifier](res, opts...)
+	return res
+}
+
+// WithWindow 设置 proof 的有效时间窗口
+func WithWindow(window time.Duration) option.Option[Verifier] {
+	return func(v *Verifier) {
+		v.window = window
+	}
+}
+
+// WithNowFunc 设置当前时间，一般用于测试
+func WithNowFunc(fn func() time.Time) option.Option[Verifier] {
+	return func(v *Verifier) {
+		v.nowFunc = fn
+	}
+}
+
+// WithHTUFunc 设置计算请求 URL 的方法，在反向代理改写了路径的时候使用
+func WithHTUFunc(fn func(ctx *gctx.Context) string) option.Option[Verifier] {
+	return func(v *Verifier) {
+		v.htuFunc = fn
+	}
+}
+
+// Thumbprint 校验请求里面的 proof，并且返回公钥的 thumbprint。
+// 一般用在登录的时候，把返回值写入到 access token 里面
+func (v *Verifier) Thumbprint(ctx *gctx.Context) (string, error) {
+	return v.verify(ctx, "")
+}
+
+// Verify 校验请求里面的 proof 是否和 access token 以及它绑定的 jkt 匹配
+func (v *Verifier) Verify(ctx *gctx.Context, accessToken string, jkt string) error {
+	if val, ok := ctx.Get(ctxVerifiedKey); ok && val == jkt {
+		return nil
+	}
+	got, err := v.verify(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if got != jkt {
+		return ErrKeyMismatch
+	}
+	ctx.Set(ctxVerifiedKey, jkt)
+	return nil
+}
+
+func (v *Verifier) verify(ctx *gctx.Context, accessToken string) (string, error) {
+	proofs := ctx.Request.Header.Values(HeaderName)
+	if len(proofs) == 0 {
+		return "", ErrProofMissing
+	}
+	if len(proofs) > 1 {
+		return "", fmt.Errorf("%w: 只能有一个 proof", ErrInvalidProof)
+	}
+	var jwk JWK
+	claims := &ProofClaims{}
+	_, err := jwt.ParseWithClaims(proofs[0], claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != proofType {
+			return nil, fmt.Errorf("typ 必须是 %s", proofType)
+		}
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(raw, &jwk); err != nil {
+			return nil, err
+		}
+		return jwk.PublicKey()
+	},
+		jwt.WithValidMethods(v.methods),
+		jwt.WithTimeFunc(v.nowFunc),
+	)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+	if err = v.checkClaims(ctx, claims, accessToken); err != nil {
+		return "", err
+	}
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+	// jti 只需要在时间窗口内去重，超过窗口的 proof 本身就会因为 iat 不合法被拒绝
+	ok, err := v.cache.Add(ctx, jkt+":"+claims.ID, 2*v.window)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrProofReplayed
+	}
+	return jkt, nil
+}
+
+func (v *Verifier) checkClaims(ctx *gctx.Context, claims *ProofClaims, accessToken string) error {
+	if claims.ID == "" {
+		return fmt.Errorf("%w: 缺少 jti", ErrInvalidProof)
+	}
+	if claims.IssuedAt == nil {
+		return fmt.Errorf("%w: 缺少 iat", ErrInvalidProof)
+	}
+	now := v.nowFunc()
+	iat := claims.IssuedAt.Time
+	if iat.Before(now.Add(-v.window)) || iat.After(now.Add(v.window)) {
+		return fmt.Errorf("%w: iat 不在有效时间窗口内", ErrInvalidProof)
+	}
+	if claims.HTM != ctx.Request.Method {
+		return fmt.Errorf("%w: htm 不匹配", ErrInvalidProof)
+	}
+	if !sameURL(claims.HTU, v.htuFunc(ctx)) {
+		return fmt.Errorf("%w: htu 不匹配", ErrInvalidProof)
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return fmt.Errorf("%w: ath 不匹配", ErrInvalidProof)
+		}
+	}
+	return nil
+}
+
+// sameURL 比较两个 URL，忽略 query、fragment 以及 scheme 和 host 的大小写
+func sameURL(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Host, ub.Host) &&
+		ua.EscapedPath() == ub.EscapedPath()
+}
+
+func defaultHTU(ctx *gctx.Context) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.Request.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + ctx.Request.Host + ctx.Request.URL.EscapedPath()
+}
@@ -0,0 +1,303 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dpop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ginx/gctx"
+	"github.com/ecodeclub/ginx/internal/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+const (
+	testURL         = "http://localhost/profile"
+	testAccessToken = "access-token"
+)
+
+var testNow = time.UnixMilli(1695571200000)
+
+type memoryReplayCache struct {
+	used map[string]struct{}
+}
+
+func (m *memoryReplayCache) Add(ctx context.Context, jti string, ttl time.Duration) (bool, error) {
+	if _, ok := m.used[jti]; ok {
+		return false, nil
+	}
+	m.used[jti] = struct{}{}
+	return true, nil
+}
+
+type proofParams struct {
+	key *ecdsa.PrivateKey
+	typ string
+	htm string
+	htu string
+	ath string
+	jti string
+	iat time.Time
+}
+
+func newProof(t *testing.T, p proofParams) string {
+	jwk, err := NewJWK(&p.key.PublicKey)
+	require.NoError(t, err)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, ProofClaims{
+		HTM: p.htm,
+		HTU: p.htu,
+		ATH: p.ath,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       p.jti,
+			IssuedAt: jwt.NewNumericDate(p.iat),
+		},
+	})
+	token.Header["typ"] = p.typ
+	token.Header["jwk"] = jwk
+	res, err := token.SignedString(p.key)
+	require.NoError(t, err)
+	return res
+}
+
+func newTestContext(method string, proofs ...string) *gctx.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(method, testURL+"?page=1", nil)
+	for _, proof := range proofs {
+		ctx.Request.Header.Add(HeaderName, proof)
+	}
+	return &gctx.Context{Context: ctx}
+}
+
+func athOf(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk, err := NewJWK(&key.PublicKey)
+	require.NoError(t, err)
+	jkt, err := jwk.Thumbprint()
+	require.NoError(t, err)
+
+	valid := func() proofParams {
+		return proofParams{
+			key: key,
+			typ: proofType,
+			htm: http.MethodGet,
+			htu: testURL,
+			ath: athOf(testAccessToken),
+			jti: "jti-1",
+			iat: testNow,
+		}
+	}
+	testCases := []struct {
+		name    string
+		ctx     func(t *testing.T) *gctx.Context
+		wantErr error
+	}{
+		{
+			name: "校验通过",
+			ctx: func(t *testing.T) *gctx.Context {
+				return newTestContext(http.MethodGet, newProof(t, valid()))
+			},
+		},
+		{
+			name: "没有 proof",
+			ctx: func(t *testing.T) *gctx.Context {
+				return newTestContext(http.MethodGet)
+			},
+			wantErr: ErrProofMissing,
+		},
+		{
+			name: "多个 proof",
+			ctx: func(t *testing.T) *gctx.Context {
+				return newTestContext(http.MethodGet, newProof(t, valid()), newProof(t, valid()))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "typ 不对",
+			ctx: func(t *testing.T) *gctx.Context {
+				p := valid()
+				p.typ = "JWT"
+				return newTestContext(http.MethodGet, newProof(t, p))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "方法不匹配",
+			ctx: func(t *testing.T) *gctx.Context {
+				return newTestContext(http.MethodPost, newProof(t, valid()))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "URL 不匹配",
+			ctx: func(t *testing.T) *gctx.Context {
+				p := valid()
+				p.htu = "http://localhost/other"
+				return newTestContext(http.MethodGet, newProof(t, p))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "ath 不匹配",
+			ctx: func(t *testing.T) *gctx.Context {
+				p := valid()
+				p.ath = athOf("other-token")
+				return newTestContext(http.MethodGet, newProof(t, p))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "proof 过期",
+			ctx: func(t *testing.T) *gctx.Context {
+				p := valid()
+				p.iat = testNow.Add(-2 * time.Minute)
+				return newTestContext(http.MethodGet, newProof(t, p))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "没有 jti",
+			ctx: func(t *testing.T) *gctx.Context {
+				p := valid()
+				p.jti = ""
+				return newTestContext(http.MethodGet, newProof(t, p))
+			},
+			wantErr: ErrInvalidProof,
+		},
+		{
+			name: "公钥和 token 绑定的不一致",
+			ctx: func(t *testing.T) *gctx.Context {
+				p := valid()
+				p.key = otherKey
+				return newTestContext(http.MethodGet, newProof(t, p))
+			},
+			wantErr: ErrKeyMismatch,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewVerifier(&memoryReplayCache{used: map[string]struct{}{}},
+				WithNowFunc(func() time.Time {
+					return testNow
+				}))
+			err := v.Verify(tc.ctx(t), testAccessToken, jkt)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestVerifier_Replay(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	v := NewVerifier(&memoryReplayCache{used: map[string]struct{}{}},
+		WithNowFunc(func() time.Time {
+			return testNow
+		}))
+	jwk, err := NewJWK(&key.PublicKey)
+	require.NoError(t, err)
+	jkt, err := jwk.Thumbprint()
+	require.NoError(t, err)
+	proof := newProof(t, proofParams{
+		key: key,
+		typ: proofType,
+		htm: http.MethodGet,
+		htu: testURL,
+		ath: athOf(testAccessToken),
+		jti: "jti-1",
+		iat: testNow,
+	})
+	ctx := newTestContext(http.MethodGet, proof)
+	require.NoError(t, v.Verify(ctx, testAccessToken, jkt))
+	// 同一个请求内再次校验不算重放
+	assert.NoError(t, v.Verify(ctx, testAccessToken, jkt))
+
+	// 换一个请求使用同一个 proof 就是重放
+	err = v.Verify(newTestContext(http.MethodGet, proof), testAccessToken, jkt)
+	assert.ErrorIs(t, err, ErrProofReplayed)
+}
+
+func TestRedisReplayCache_Add(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mock    func(ctrl *gomock.Controller) redis.Cmdable
+		want    bool
+		wantErr error
+	}{
+		{
+			name: "第一次使用",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewBoolCmd(context.Background())
+				res.SetVal(true)
+				cmd.EXPECT().SetNX(gomock.Any(), "dpop:jti:abc", 1, time.Minute).Return(res)
+				return cmd
+			},
+			want: true,
+		},
+		{
+			name: "重放",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewBoolCmd(context.Background())
+				res.SetVal(false)
+				cmd.EXPECT().SetNX(gomock.Any(), "dpop:jti:abc", 1, time.Minute).Return(res)
+				return cmd
+			},
+			want: false,
+		},
+		{
+			name: "redis 错误",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewBoolCmd(context.Background())
+				res.SetErr(errors.New("模拟 redis 错误"))
+				cmd.EXPECT().SetNX(gomock.Any(), "dpop:jti:abc", 1, time.Minute).Return(res)
+				return cmd
+			},
+			wantErr: errors.New("模拟 redis 错误"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			c := NewRedisReplayCache(tc.mock(ctrl))
+			got, err := c.Add(context.Background(), "abc", time.Minute)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
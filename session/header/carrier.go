@@ -39,7 +39,14 @@ func (t *TokenCarrier) Inject(ctx *gctx.Context, value string) {
 // Extract 固定从 Authorization 中提取
 func (t *TokenCarrier) Extract(ctx *gctx.Context) string {
 	token := ctx.Request.Header.Get("Authorization")
-	const bearerPrefix = "Bearer "
+	const (
+		bearerPrefix = "Bearer "
+		// 开启了 DPoP 之后，客户端使用的是 DPoP 认证方式
+		dpopPrefix = "DPoP "
+	)
+	if strings.HasPrefix(token, dpopPrefix) {
+		return strings.TrimPrefix(token, dpopPrefix)
+	}
 	return strings.TrimPrefix(token, bearerPrefix)
 }
 
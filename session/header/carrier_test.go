@@ -58,6 +58,22 @@ func (s *CarrierTestSuite) TestExtract() {
 	assert.Equal(s.T(), val, res)
 }
 
+func (s *CarrierTestSuite) TestExtractDPoP() {
+	instance := NewTokenCarrier()
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	val := "this is token"
+	ctx.Request = &http.Request{
+		Header: http.Header{
+			"Authorization": []string{fmt.Sprintf("DPoP %s", val)},
+		},
+	}
+	res := instance.Extract(&ginx.Context{
+		Context: ctx,
+	})
+	assert.Equal(s.T(), val, res)
+}
+
 func (s *CarrierTestSuite) TestClear() {
 	instance := &TokenCarrier{
 		Name: "ssid",
@@ -17,6 +17,8 @@ package redis
 import (
 	"time"
 
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/ginx/session/dpop"
 	"github.com/ecodeclub/ginx/session/header"
 
 	"github.com/ecodeclub/ginx"
@@ -38,6 +40,11 @@ type SessionProvider struct {
 	m            ijwt.Manager[session.Claims]
 	TokenCarrier session.TokenCarrier
 	expiration   time.Duration
+	// 不为空的时候，token 会使用 JWE 加密，claims 里面的数据不能被直接读取
+	jweKey []byte
+	// 不为空的时候开启 DPoP，token 会绑定到客户端的公钥上，
+	// 每个请求都必须携带合法的 DPoP proof
+	dpop *dpop.Verifier
 }
 
 func (rsp *SessionProvider) Destroy(ctx *gctx.Context) error {
@@ -68,6 +75,9 @@ func (rsp *SessionProvider) RenewAccessToken(ctx *ginx.Context) error {
 		return err
 	}
 	claims := jwtClaims.Data
+	if err = rsp.verifyProof(ctx, rt, claims); err != nil {
+		return err
+	}
 	accessToken, err := rsp.m.GenerateAccessToken(claims)
 	rsp.TokenCarrier.Inject(ctx, accessToken)
 	return err
@@ -83,6 +93,14 @@ func (rsp *SessionProvider) NewSession(ctx *gctx.Context,
 		SSID:       ssid,
 		Expiration: time.Now().Add(rsp.expiration).UnixMilli(),
 		Data:       jwtData}
+	if rsp.dpop != nil {
+		// 登录请求里面的 proof 决定了 token 绑定哪个公钥
+		jkt, err := rsp.dpop.Thumbprint(ctx)
+		if err != nil {
+			return nil, err
+		}
+		claims.JKT = jkt
+	}
 	accessToken, err := rsp.m.GenerateAccessToken(claims)
 	if err != nil {
 		return nil, err
@@ -110,19 +128,57 @@ func (rsp *SessionProvider) Get(ctx *gctx.Context) (session.Session, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = rsp.verifyProof(ctx, token, claims.Data); err != nil {
+		return nil, err
+	}
 	res = newRedisSession(claims.Data.SSID, rsp.expiration, rsp.client, claims.Data)
 	return res, nil
 }
 
+// verifyProof 在开启了 DPoP 的时候校验请求携带的 proof
+func (rsp *SessionProvider) verifyProof(ctx *gctx.Context, token string, claims session.Claims) error {
+	if rsp.dpop == nil {
+		return nil
+	}
+	// 开启了 DPoP 之后，没有绑定公钥的 token 也是不能用的
+	if claims.JKT == "" {
+		return dpop.ErrKeyMismatch
+	}
+	return rsp.dpop.Verify(ctx, token, claims.JKT)
+}
+
 // NewSessionProvider 用于管理 Session
 func NewSessionProvider(client redis.Cmdable, jwtKey string,
-	expiration time.Duration) *SessionProvider {
-	// 长 token 过期时间，被看做是 Session 的过期时间
-	m := ijwt.NewManagement[session.Claims](ijwt.NewOptions(expiration, jwtKey))
-	return &SessionProvider{
+	expiration time.Duration, opts ...option.Option[SessionProvider]) *SessionProvider {
+	res := &SessionProvider{
 		client:       client,
 		TokenCarrier: header.NewTokenCarrier(),
-		m:            m,
 		expiration:   expiration,
 	}
+	option.Apply[SessionProvider](res, opts...)
+	var jwtOpts []option.Option[ijwt.Options]
+	if len(res.jweKey) > 0 {
+		jwtOpts = append(jwtOpts, ijwt.WithJWEKey(res.jweKey))
+	}
+	// 长 token 过期时间，被看做是 Session 的过期时间
+	res.m = ijwt.NewManagement[session.Claims](ijwt.NewOptions(expiration, jwtKey, jwtOpts...))
+	return res
+}
+
+// WithJWEKey 开启 JWE 模式，签名后的 token 会再加密一次，
+// 这样 Claims.Data 里面的数据对客户端来说是不可读的。
+// key 的长度必须是 16、24 或者 32 字节
+func WithJWEKey(key []byte) option.Option[SessionProvider] {
+	return func(p *SessionProvider) {
+		p.jweKey = key
+	}
+}
+
+// WithDPoP 开启 DPoP 模式。
+// 登录的时候请求必须携带 DPoP proof，生成的 token 会绑定 proof 里面的公钥，
+// 之后 Get 和 RenewAccessToken 都要求请求携带同一个公钥签名的、新鲜的、没有被重放过的 proof
+func WithDPoP(v *dpop.Verifier) option.Option[SessionProvider] {
+	return func(p *SessionProvider) {
+		p.dpop = v
+	}
 }
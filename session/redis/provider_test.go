@@ -17,7 +17,15 @@
 package redis
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,7 +35,9 @@ import (
 	"github.com/ecodeclub/ginx/gctx"
 	"github.com/ecodeclub/ginx/internal/mocks"
 	"github.com/ecodeclub/ginx/session"
+	"github.com/ecodeclub/ginx/session/dpop"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -151,3 +161,125 @@ func TestSessionProvider_NewSession(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionProvider_JWE(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	pip := mocks.NewMockPipeliner(ctrl)
+	pip.EXPECT().HMSet(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().Return(nil)
+	pip.EXPECT().Expire(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	pip.EXPECT().Exec(gomock.Any()).Return(nil, nil)
+	cmd.EXPECT().Pipeline().Return(pip)
+
+	sp := NewSessionProvider(cmd, "123", time.Minute,
+		WithJWEKey([]byte("0123456789abcdef0123456789abcdef")))
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	_, err := sp.NewSession(&gctx.Context{Context: ctx}, 123,
+		map[string]string{"tenant": "t1"}, map[string]any{})
+	require.NoError(t, err)
+	token := ctx.Writer.Header().Get("X-Access-Token")
+	// JWE compact 格式是五段
+	assert.Len(t, strings.Split(token, "."), 5)
+
+	rc, err := sp.m.VerifyAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "t1", rc.Data.Data["tenant"])
+
+	// 用没有开启 JWE 的 provider 解析失败
+	plain := NewSessionProvider(cmd, "123", time.Minute)
+	_, err = plain.m.VerifyAccessToken(token)
+	assert.Error(t, err)
+}
+
+type memoryReplayCache struct {
+	used map[string]struct{}
+}
+
+func (m *memoryReplayCache) Add(ctx context.Context, jti string, ttl time.Duration) (bool, error) {
+	if _, ok := m.used[jti]; ok {
+		return false, nil
+	}
+	m.used[jti] = struct{}{}
+	return true, nil
+}
+
+func TestSessionProvider_DPoP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	pip := mocks.NewMockPipeliner(ctrl)
+	pip.EXPECT().HMSet(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().Return(nil)
+	pip.EXPECT().Expire(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	pip.EXPECT().Exec(gomock.Any()).Return(nil, nil)
+	cmd.EXPECT().Pipeline().Return(pip)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk, err := dpop.NewJWK(&key.PublicKey)
+	require.NoError(t, err)
+	newProof := func(method, url, accessToken, jti string) string {
+		claims := dpop.ProofClaims{
+			HTM: method,
+			HTU: url,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:       jti,
+				IssuedAt: jwt.NewNumericDate(time.Now()),
+			},
+		}
+		if accessToken != "" {
+			sum := sha256.Sum256([]byte(accessToken))
+			claims.ATH = base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = jwk
+		res, err := token.SignedString(key)
+		require.NoError(t, err)
+		return res
+	}
+	newCtx := func(method, url, accessToken, proof string) *gctx.Context {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(method, url, nil)
+		if accessToken != "" {
+			ctx.Request.Header.Set("Authorization", "DPoP "+accessToken)
+		}
+		if proof != "" {
+			ctx.Request.Header.Set(dpop.HeaderName, proof)
+		}
+		return &gctx.Context{Context: ctx}
+	}
+
+	sp := NewSessionProvider(cmd, "123", time.Minute,
+		WithDPoP(dpop.NewVerifier(&memoryReplayCache{used: map[string]struct{}{}})))
+
+	// 登录的时候没有 proof
+	_, err = sp.NewSession(newCtx(http.MethodPost, "http://localhost/login", "", ""),
+		123, nil, map[string]any{})
+	assert.ErrorIs(t, err, dpop.ErrProofMissing)
+
+	loginCtx := newCtx(http.MethodPost, "http://localhost/login", "",
+		newProof(http.MethodPost, "http://localhost/login", "", "login"))
+	sess, err := sp.NewSession(loginCtx, 123, nil, map[string]any{})
+	require.NoError(t, err)
+	jkt, err := jwk.Thumbprint()
+	require.NoError(t, err)
+	assert.Equal(t, jkt, sess.Claims().JKT)
+	token := loginCtx.Writer.Header().Get("X-Access-Token")
+
+	// 携带合法的 proof
+	proof := newProof(http.MethodGet, "http://localhost/profile", token, "p1")
+	_, err = sp.Get(newCtx(http.MethodGet, "http://localhost/profile", token, proof))
+	require.NoError(t, err)
+
+	// 重放 proof
+	_, err = sp.Get(newCtx(http.MethodGet, "http://localhost/profile", token, proof))
+	assert.ErrorIs(t, err, dpop.ErrProofReplayed)
+
+	// 只有 token 没有 proof
+	_, err = sp.Get(newCtx(http.MethodGet, "http://localhost/profile", token, ""))
+	assert.ErrorIs(t, err, dpop.ErrProofMissing)
+}
@@ -70,6 +70,8 @@ type Claims struct {
 	Data map[string]string
 	// 过期时间。毫秒数
 	Expiration int64
+	// JKT 是 DPoP 公钥的 thumbprint，不为空说明这个 token 绑定了客户端的公钥
+	JKT string `json:",omitempty"`
 }
 
 func (c Claims) Get(key string) ekit.AnyValue {